@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ATA commands smart.go does not implement, sent with ATA PASS-THROUGH(16)
+
+const (
+	scsi_ata_passthru_16 = 0x85
+
+	ata_smart = 0xb0
+
+	smart_read_data       = 0xd0
+	smart_execute_offline = 0xd4
+	smart_read_log        = 0xd5
+	smart_write_log       = 0xd6
+	smart_return_status   = 0xda
+
+	ata_read_log_ext = 0x2f
+	ata_log_page     = 512
+)
+
+var errNoAtaStatus = errors.New("no ATA status return descriptor in sense data")
+
+// ataSmartCdb builds an ATA PASS-THROUGH(16) cdb of the SMART command with feature
+func ataSmartCdb(feature uint8) (cdb [16]byte) {
+	cdb[0] = scsi_ata_passthru_16
+	cdb[4] = feature
+	cdb[10] = 0x4f // lba mid
+	cdb[12] = 0xc2 // lba high
+	cdb[14] = ata_smart
+	return
+}
+
+// ataStatusReturn finds the ATA Status Return descriptor in descriptor format sense
+// data and returns the ATA output registers lba mid and lba high
+func ataStatusReturn(sense []byte) (lba_mid, lba_high uint8, err error) {
+	if len(sense) < 8 || sense[0]&0x7f != 0x72 {
+		err = errNoAtaStatus
+		return
+	}
+	end := min(8+int(sense[7]), len(sense))
+	for i := 8; i+1 < end; i += 2 + int(sense[i+1]) {
+		if sense[i] == 0x09 && i+14 <= end {
+			return sense[i+9], sense[i+11], nil
+		}
+	}
+	err = errNoAtaStatus
+	return
+}
+
+// ataSmartReturnStatus sends SMART RETURN STATUS, the result is reported in the
+// lba mid/high registers so CK_COND is set to get them back in the sense data
+func ataSmartReturnStatus(fd int) (passed bool, err error) {
+	cdb := ataSmartCdb(smart_return_status)
+	cdb[1] = 0x06 // ATA protocol (3 << 1, non-data)
+	cdb[2] = 0x20 // CK_COND = 1
+	err = scsiSendCdb(fd, cdb[:], sg_dxfer_none, nil)
+	var sgerr *sgioError
+	if !errors.As(err, &sgerr) {
+		if err == nil {
+			err = errNoAtaStatus
+		}
+		return
+	}
+	mid, high, err := ataStatusReturn(sgerr.sense)
+	if err != nil {
+		return
+	}
+	switch {
+	case mid == 0x4f && high == 0xc2:
+		return true, nil
+	case mid == 0xf4 && high == 0x2c:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected SMART RETURN STATUS registers %#02x %#02x", mid, high)
+	}
+}
+
+// ataReadLogExt reads len(buf)/512 pages of the general purpose log starting at page
+func ataReadLogExt(fd int, log uint8, page uint16, buf []byte) error {
+	count := len(buf) / ata_log_page
+	var cdb [16]byte
+	cdb[0] = scsi_ata_passthru_16
+	cdb[1] = 0x09 // ATA protocol (4 << 1, PIO data-in), EXTEND = 1
+	cdb[2] = 0x0e // T_DIR = 1, BYT_BLOK = 1, T_LENGTH = 2 (count in sector count)
+	cdb[5] = uint8(count >> 8)
+	cdb[6] = uint8(count)
+	cdb[8] = log              // lba (7:0)
+	cdb[9] = uint8(page >> 8) // lba (39:32)
+	cdb[10] = uint8(page)     // lba (15:8)
+	cdb[14] = ata_read_log_ext
+	return scsiSendCdb(fd, cdb[:], sg_dxfer_from_dev, buf)
+}
+
+// ataGpLogPages returns the number of pages of log from the general purpose log
+// directory, 0 if the log is not supported
+func ataGpLogPages(fd int, log uint8) (int, error) {
+	buf := make([]byte, ata_log_page)
+	if err := ataReadLogExt(fd, 0x00, 0, buf); err != nil {
+		return 0, err
+	}
+	return int(binary.LittleEndian.Uint16(buf[int(log)*2:])), nil
+}
+
+// ataSmartRead reads len(buf)/512 sectors with SMART READ DATA or SMART READ LOG,
+// lba_low is the log address for the latter
+func ataSmartRead(fd int, feature, lba_low uint8, buf []byte) error {
+	cdb := ataSmartCdb(feature)
+	cdb[1] = 0x08 // ATA protocol (4 << 1, PIO data-in)
+	cdb[2] = 0x0e // T_DIR = 1, BYT_BLOK = 1, T_LENGTH = 2 (count in sector count)
+	cdb[6] = uint8(len(buf) / ata_log_page)
+	cdb[8] = lba_low
+	return scsiSendCdb(fd, cdb[:], sg_dxfer_from_dev, buf)
+}
+
+// ataSmartWriteLog writes buf to the SMART log address log
+func ataSmartWriteLog(fd int, log uint8, buf []byte) error {
+	cdb := ataSmartCdb(smart_write_log)
+	cdb[1] = 0x0a // ATA protocol (5 << 1, PIO data-out)
+	cdb[2] = 0x06 // T_DIR = 0, BYT_BLOK = 1, T_LENGTH = 2 (count in sector count)
+	cdb[6] = uint8(len(buf) / ata_log_page)
+	cdb[8] = log
+	return scsiSendCdb(fd, cdb[:], sg_dxfer_to_dev, buf)
+}
+
+// ataSmartExecuteOffline starts the off-line routine subcommand, like a self-test
+func ataSmartExecuteOffline(fd int, subcommand uint8) error {
+	cdb := ataSmartCdb(smart_execute_offline)
+	cdb[1] = 0x06 // ATA protocol (3 << 1, non-data)
+	cdb[8] = subcommand
+	return scsiSendCdb(fd, cdb[:], sg_dxfer_none, nil)
+}
@@ -16,12 +16,28 @@ type collector struct {
 
 var blacklist_devs = []string{"loop", "zram", "zd", "sr"}
 
+const (
+	deviceHealthOk     = metric_head + "device_health_ok"
+	deviceHealthReason = metric_head + "device_health_reason"
+)
+
+var (
+	tags_dev_type        = []string{tag_dev, "type"}
+	tags_dev_type_reason = []string{tag_dev, "type", "reason"}
+	health_metrics       = map[string]*prometheus.Desc{
+		deviceHealthOk:     prometheus.NewDesc(deviceHealthOk, "", tags_dev_type, nil),
+		deviceHealthReason: prometheus.NewDesc(deviceHealthReason, "", tags_dev_type_reason, nil),
+	}
+)
+
 func NewCollector(skip ...string) *collector {
 	c := collector{}
+	// namespaces of the same nvme controller share its SMART log, the controller is
+	// opened once after all block devices are listed
+	var controllers []string
+	namespaces := make(map[string][]string)
 	dir, _ := os.ReadDir("/sys/block/")
 	for _, disk := range dir {
-		var pdev PromDev
-		var err error
 		for _, prefix := range blacklist_devs {
 			if strings.HasPrefix(disk.Name(), prefix) {
 				goto SkipDev
@@ -30,18 +46,53 @@ func NewCollector(skip ...string) *collector {
 				goto SkipDev
 			}
 		}
-		pdev, err = NewPromDev(disk.Name())
+		if ctrl, ok := nvmeController(disk.Name()); ok {
+			if !slices.Contains(skip, ctrl) {
+				if _, found := namespaces[ctrl]; !found {
+					controllers = append(controllers, ctrl)
+				}
+				namespaces[ctrl] = append(namespaces[ctrl], disk.Name())
+			}
+			continue
+		}
+		c.addDev(disk.Name())
+	SkipDev:
+	}
+	for _, ctrl := range controllers {
+		pdev, err := NewNvmeController(ctrl, namespaces[ctrl])
 		if err != nil {
-			// some devices (like dmcrypt) do not support SMART interface
-			slog.Warn("failed to open smart", "dev", disk.Name(), "err", err)
+			// the controller character device may be missing, e.g. in a container
+			slog.Warn("failed to open nvme controller, using its namespaces", "dev", ctrl, "err", err)
+			for _, ns := range namespaces[ctrl] {
+				c.addDev(ns)
+			}
 			continue
 		}
 		c.devs = append(c.devs, pdev)
-	SkipDev:
 	}
 	return &c
 }
 
+func (c *collector) addDev(name string) {
+	pdev, err := NewPromDev(name)
+	if err != nil {
+		// some devices (like dmcrypt) do not support SMART interface
+		slog.Warn("failed to open smart", "dev", name, "err", err)
+		return
+	}
+	c.devs = append(c.devs, pdev)
+}
+
+// Dev returns the device by name, or nil if there is none
+func (c *collector) Dev(name string) PromDev {
+	for _, dev := range c.devs {
+		if dev.Name() == name {
+			return dev
+		}
+	}
+	return nil
+}
+
 func (c *collector) Close() {
 	for _, dev := range c.devs {
 		err := dev.Close()
@@ -52,6 +103,9 @@ func (c *collector) Close() {
 }
 
 func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range health_metrics {
+		ch <- desc
+	}
 	for _, dev := range c.devs {
 		for name, desc := range dev.ListMetrics() {
 			if slices.Contains(c.metrics_names, name) {
@@ -75,5 +129,30 @@ func (c *collector) Collect(ch chan<- prometheus.Metric) {
 			}
 			ch <- metric
 		}
+		c.collectHealth(ch, dev)
+	}
+}
+
+func (c *collector) collectHealth(ch chan<- prometheus.Metric, dev PromDev) {
+	ok, reason, err := dev.Health()
+	if err != nil {
+		slog.Warn("failed to get health", "dev", dev.Name(), "err", err)
+		return
+	}
+	value := 0.0
+	if ok {
+		value = 1
+	}
+	metrics := []PromValue{
+		{Desc: health_metrics[deviceHealthOk], Type: prometheus.GaugeValue, Value: value, Tags: []string{dev.Name(), dev.Type()}},
+		{Desc: health_metrics[deviceHealthReason], Type: prometheus.GaugeValue, Value: 0, Tags: []string{dev.Name(), dev.Type(), reason}},
+	}
+	for _, m := range metrics {
+		metric, err := prometheus.NewConstMetric(m.Desc, m.Type, m.Value, m.Tags...)
+		if err != nil {
+			slog.Warn("failed to get metric", "args", m, "err", err)
+			continue
+		}
+		ch <- metric
 	}
 }
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/anatol/smart.go"
+)
+
+// Loader for smartmontools' drivedb.h, so attribute names and raw value formats of
+// drives newer than the database built into smart.go match what smartctl prints.
+
+type driveDbAttr struct {
+	name       string // empty to keep the name from smart.go
+	format     string
+	byte_order string // most significant byte first, empty for the default of format
+}
+
+type driveDbEntry struct {
+	family   string
+	model    *regexp.Regexp
+	firmware *regexp.Regexp // nil matches every firmware
+	presets  []string       // arguments of the -v options
+}
+
+type driveDb struct {
+	defaults []string // presets of the DEFAULT entry, applied to every drive
+	entries  []driveDbEntry
+}
+
+// drive_db is set by main before devices are opened, nil if no database is loaded
+var drive_db *driveDb
+
+func LoadDriveDb(path string) (*driveDb, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDriveDb(string(b))
+}
+
+// ParseDriveDb parses the initializer list of drivedb.h. Every entry is a brace
+// enclosed list of five string fields: family, model regex, firmware regex, warning
+// and presets. Adjacent string literals are concatenated like in C.
+func ParseDriveDb(src string) (*driveDb, error) {
+	var db driveDb
+	var fields []string
+	var field strings.Builder
+	depth := 0
+	in_field := false
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; {
+		case strings.HasPrefix(src[i:], "//"):
+			i += strings.IndexByte(src[i:]+"\n", '\n')
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment at offset %d", i)
+			}
+			i += end + 3
+		case c == '"':
+			end := i + 1
+			for ; end < len(src) && src[end] != '"'; end++ {
+				if src[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			s, err := strconv.Unquote(src[i : end+1])
+			if err != nil {
+				// C escapes Go does not know are kept as is
+				s = src[i+1 : end]
+			}
+			field.WriteString(s)
+			in_field = true
+			i = end
+		case c == '{':
+			depth++
+			fields = fields[:0]
+		case c == ',' && depth == 2 && in_field:
+			fields = append(fields, field.String())
+			field.Reset()
+			in_field = false
+		case c == '}':
+			if depth == 2 {
+				if in_field {
+					fields = append(fields, field.String())
+				}
+				field.Reset()
+				in_field = false
+				db.add(fields)
+			}
+			depth--
+		}
+	}
+	return &db, nil
+}
+
+func (db *driveDb) add(fields []string) {
+	if len(fields) < 5 {
+		return
+	}
+	family, model, firmware, presets := fields[0], fields[1], fields[2], strings.Fields(fields[4])
+	switch {
+	case family == "DEFAULT":
+		db.defaults = presets
+		return
+	case strings.HasPrefix(family, "VERSION:"), strings.HasPrefix(family, "USB:"):
+		return
+	}
+	e := driveDbEntry{family: family, presets: presets}
+	var err error
+	// smartctl matches the whole string with POSIX extended regular expressions
+	if e.model, err = regexp.Compile("^(?:" + model + ")$"); err != nil {
+		slog.Debug("failed to compile drivedb model regex", "family", family, "err", err)
+		return
+	}
+	if len(firmware) != 0 {
+		if e.firmware, err = regexp.Compile("^(?:" + firmware + ")$"); err != nil {
+			slog.Debug("failed to compile drivedb firmware regex", "family", family, "err", err)
+			return
+		}
+	}
+	db.entries = append(db.entries, e)
+}
+
+// Lookup returns the attribute presets for a drive, the DEFAULT entry overlaid with the
+// first entry matching model and firmware like smartctl does
+func (db *driveDb) Lookup(model, firmware string, ssd bool) (family string, attrs map[uint8]driveDbAttr) {
+	attrs = make(map[uint8]driveDbAttr)
+	parsePresets(db.defaults, ssd, attrs)
+	for _, e := range db.entries {
+		if !e.model.MatchString(model) || e.firmware != nil && !e.firmware.MatchString(firmware) {
+			continue
+		}
+		parsePresets(e.presets, ssd, attrs)
+		return e.family, attrs
+	}
+	return
+}
+
+var drivedb_name_replacer = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// parsePresets applies the "-v ID,FORMAT[:BYTEORDER][,NAME[,HDD|SSD]]" options of
+// presets to attrs, other options are ignored
+func parsePresets(presets []string, ssd bool, attrs map[uint8]driveDbAttr) {
+	for i := 0; i+1 < len(presets); i++ {
+		if presets[i] != "-v" {
+			continue
+		}
+		i++
+		parts := strings.Split(presets[i], ",")
+		if len(parts) < 2 {
+			continue
+		}
+		id, err := strconv.ParseUint(parts[0], 10, 8)
+		if err != nil {
+			// "N" applies to all attributes, not used for names or formats
+			continue
+		}
+		if len(parts) > 3 && (parts[3] == "HDD" && ssd || parts[3] == "SSD" && !ssd) {
+			continue
+		}
+		var attr driveDbAttr
+		attr.format, attr.byte_order, _ = strings.Cut(parts[1], ":")
+		if len(parts) > 2 {
+			attr.name = drivedb_name_replacer.ReplaceAllString(parts[2], "_")
+		}
+		attrs[uint8(id)] = attr
+	}
+}
+
+// order returns the byte order, with the defaults of smartctl
+func (a driveDbAttr) order() string {
+	if len(a.byte_order) != 0 {
+		return a.byte_order
+	}
+	switch a.format {
+	case "raw56", "hex56", "raw24/raw32", "msec24hour32":
+		return "r543210"
+	case "raw64", "hex64":
+		return "vr543210"
+	default:
+		return "543210"
+	}
+}
+
+// rawValue assembles the raw value from the attribute bytes in byte order. smart.go
+// does not expose the reserved byte, it is passed separately.
+func (a driveDbAttr) rawValue(attr smart.AtaSmartAttr, reserved uint8) (raw uint64) {
+	for _, c := range a.order() {
+		var b uint8
+		switch {
+		case c >= '0' && c <= '5':
+			b = attr.VendorBytes[c-'0']
+		case c == 'v':
+			b = attr.Current
+		case c == 'w':
+			b = attr.Worst
+		case c == 'r':
+			b = reserved
+		}
+		raw = raw<<8 | uint64(b)
+	}
+	return
+}
+
+// Value decodes the raw value of attr into the main number smartctl prints: the first
+// of the two values of ratios, the value without the parenthesized extras, durations
+// in hours and temperatures in Celsius
+func (a driveDbAttr) Value(attr smart.AtaSmartAttr, reserved uint8) float64 {
+	raw := a.rawValue(attr, reserved)
+	switch a.format {
+	case "raw16(raw16)", "raw16(avg16)":
+		return float64(raw & 0xffff)
+	case "raw24(raw8)":
+		return float64(raw & 0xffffff)
+	case "raw24/raw24":
+		return float64(raw >> 24 & 0xffffff)
+	case "raw24/raw32":
+		return float64(raw >> 32 & 0xffffff)
+	case "sec2hour":
+		return float64(raw) / 3600
+	case "min2hour":
+		return float64(raw) / 60
+	case "halfmin2hour":
+		return float64(raw) / 120
+	case "msec24hour32":
+		return float64(raw&0xffffffff) + float64(raw>>32&0xffffff)/3600000
+	case "tempminmax":
+		return float64(int8(raw))
+	case "temp10x":
+		return float64(raw&0xffff) / 10
+	default:
+		// raw8, raw16, raw48, hex48, raw56, hex56, raw64 and hex64 only differ in
+		// how smartctl prints them
+		return float64(raw)
+	}
+}
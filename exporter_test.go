@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"slices"
 	"testing"
 
 	"github.com/anatol/smart.go"
@@ -72,3 +75,375 @@ func TestParseSpinUpTime(t *testing.T) {
 		t.Errorf("incorrect value %d %d", cur, avg)
 	}
 }
+
+func TestParseDriveDb(t *testing.T) {
+	src := `
+const drive_settings builtin_knowndrives[] = {
+  { "VERSION: 7.4 $Id$",
+    "-", "-",
+    "Version information",
+    ""
+  },
+  { "DEFAULT",
+    "-", "-", "",
+    "-v 9,raw48,Power_On_Hours "
+    "-v 194,tempminmax,Temperature_Celsius"
+  },
+  /* Seagate, see https://www.seagate.com/ */
+  { "Seagate Barracuda 7200.14 (AF)", // tested with ST1000DM003-1CH162/CC47
+    "ST(1000|2000)DM00[0-3]-.*",
+    "",
+    "A firmware update is available, see \"https://example.com/\"",
+    "-v 1,raw24/raw32 -v 9,msec24hour32,Power_On_Hours_and_Msec -v 190,raw48,Airflow/Temp,HDD"
+  },
+};`
+	db, err := ParseDriveDb(src)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	family, attrs := db.Lookup("ST1000DM003-1CH162", "CC47", false)
+	if family != "Seagate Barracuda 7200.14 (AF)" || attrs[9].name != "Power_On_Hours_and_Msec" || attrs[194].format != "tempminmax" || attrs[190].name != "Airflow_Temp" {
+		t.Errorf("incorrect value %s %v", family, attrs)
+	}
+	if _, attrs = db.Lookup("ST1000DM003-1CH162", "CC47", true); attrs[190].name != "" {
+		t.Errorf("HDD only preset applied to SSD %v", attrs)
+	}
+	if family, attrs = db.Lookup("WDC WD10EZEX", "01.01A01", false); family != "" || attrs[9].name != "Power_On_Hours" {
+		t.Errorf("incorrect default %s %v", family, attrs)
+	}
+
+	// 5 errors out of 0x100000 reads
+	attr := smart.AtaSmartAttr{VendorBytes: [6]byte{0x00, 0x00, 0x10, 0x00, 0x05, 0x00}}
+	if v := (driveDbAttr{format: "raw24/raw32"}).Value(attr, 0); v != 5 {
+		t.Errorf("incorrect raw24/raw32 %v", v)
+	}
+	if v := (driveDbAttr{format: "raw56", byte_order: "r543210"}).Value(attr, 0x01); v != 0x01000500100000 {
+		t.Errorf("incorrect r543210 %x", uint64(v))
+	}
+	// 100 hours and 36000 ms
+	attr.VendorBytes = [6]byte{100, 0, 0, 0, 0xa0, 0x8c}
+	if v := (driveDbAttr{format: "msec24hour32"}).Value(attr, 0); math.Abs(v-100.01) > 1e-9 {
+		t.Errorf("incorrect msec24hour32 %v", v)
+	}
+}
+
+func TestParseAtaSmartData(t *testing.T) {
+	page := make([]byte, ata_log_page)
+	copy(page[2:], []byte{9, 0x32, 0, 100, 99, 1, 2, 3, 4, 5, 6, 0x7f})
+	copy(page[14:], []byte{1, 0x0f, 0, 90, 80, 0, 0, 0, 0, 5, 0, 0x01})
+	mapping := map[uint8]sataAttrMapping{
+		9: {"Power_On_Hours", smart.AtaDeviceAttributeTypeRaw48},
+		1: {"Raw_Read_Error_Rate", smart.AtaDeviceAttributeTypeRaw24DivRaw32},
+	}
+	attrs, reserved := ParseAtaSmartData(page, mapping)
+	if len(attrs) != 2 || reserved[9] != 0x7f || reserved[1] != 0x01 {
+		t.Errorf("incorrect value %v %v", attrs, reserved)
+		return
+	}
+	if a := attrs[9]; a.Name != "Power_On_Hours" || a.Flags != 0x32 || a.Current != 100 || a.Worst != 99 || a.ValueRaw != 0x060504030201 {
+		t.Errorf("incorrect attribute %+v", a)
+	}
+	// the reserved byte is the most significant one, like in smart.go
+	if a := attrs[1]; a.ValueRaw != 0x01000500000000 {
+		t.Errorf("incorrect attribute %+v", a)
+	}
+}
+
+func TestParseScsiLogPage(t *testing.T) {
+	// temperature page with current and reference temperature
+	buf := []byte{0x0d, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x03, 0x02, 0x00, 0x24, 0x00, 0x01, 0x03, 0x02, 0x00, 0x41}
+	params, err := parseScsiLogPage(0x0d, buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cur, ref := parseScsiTemperature(params)
+	if cur != 36 || ref != 65 {
+		t.Errorf("incorrect value %d %d", cur, ref)
+	}
+}
+
+func TestAttributeFailed(t *testing.T) {
+	cases := []struct {
+		current, worst, thresh uint8
+		now, past              bool
+	}{
+		{100, 100, 36, false, false},
+		{30, 30, 36, true, false},
+		{100, 20, 36, false, true},
+		{0, 0, 0, false, false},
+	}
+	for _, c := range cases {
+		now, past := AttributeFailed(c.current, c.worst, c.thresh)
+		if now != c.now || past != c.past {
+			t.Errorf("incorrect value %v %v for %v", now, past, c)
+		}
+	}
+}
+
+func TestAtaStatusReturn(t *testing.T) {
+	sense := []byte{0x72, 0x01, 0x00, 0x1d, 0x00, 0x00, 0x00, 0x0e,
+		0x09, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf4, 0x00, 0x2c, 0x00, 0x50}
+	mid, high, err := ataStatusReturn(sense)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if mid != 0xf4 || high != 0x2c {
+		t.Errorf("incorrect value %#02x %#02x", mid, high)
+	}
+}
+
+func TestParseAtaDeviceStatistics(t *testing.T) {
+	buf := make([]byte, ata_log_page)
+	buf[2] = 0x05                                        // page number
+	copy(buf[8:], []byte{0xfb, 0, 0, 0, 0, 0, 0, 0xc0})  // current temperature -5, supported and valid
+	copy(buf[32:], []byte{0x37, 0, 0, 0, 0, 0, 0, 0x80}) // highest temperature, not valid
+	v := ParseAtaDeviceStatistics(0x05, buf)
+	if len(v) != 1 || v["current_temperature_celsius"].Value != -5 {
+		t.Errorf("incorrect value %v", v)
+	}
+	if len(ParseAtaDeviceStatistics(0x01, buf)) != 0 {
+		t.Errorf("page number mismatch should not be parsed")
+	}
+}
+
+func TestParseAtaErrorLogSummary(t *testing.T) {
+	log := smart.AtaSmartErrorLogSummary{LogIndex: 2, ErrorCount: 7}
+	log.LogData[0][61] = 0x84                           // ICRC ABRT
+	log.LogData[1][61] = 0x40                           // UNC
+	log.LogData[1][88], log.LogData[1][89] = 0x10, 0x27 // 10000 hours
+	entries, count := ParseAtaErrorLogSummary(&log)
+	if count != 7 || len(entries) != 2 {
+		t.Errorf("incorrect entries %v", entries)
+		return
+	}
+	if entries[0].Number != 7 || entries[0].Error != 0x40 || entries[0].Hours != 10000 || entries[1].Number != 6 || entries[1].Error != 0x84 {
+		t.Errorf("incorrect value %+v", entries)
+	}
+}
+
+func TestParseAtaSelfTestLog(t *testing.T) {
+	log := smart.AtaSmartSelfTestLog{Index: 2}
+	log.Entry[0].LBA_7, log.Entry[0].LifeTimestamp = 0x02, 100 // extended, completed
+	log.Entry[1].LBA_7, log.Entry[1].Status = 0x81, 0x73       // short captive, read failure
+	log.Entry[1].LifeTimestamp, log.Entry[1].LBA = 200, 0x1234
+	entries := ParseAtaSelfTestLog(&log)
+	if len(entries) != 2 {
+		t.Errorf("incorrect entries %v", entries)
+		return
+	}
+	if entries[0].Type != "short" || entries[0].Status != 7 || !entries[0].Failed() || entries[0].LBA != 0x1234 || entries[0].Hours != 200 {
+		t.Errorf("incorrect value %+v", entries[0])
+	}
+	if entries[1].Type != "extended" || entries[1].Status != 0 || entries[1].Failed() || entries[1].Hours != 100 {
+		t.Errorf("incorrect value %+v", entries[1])
+	}
+}
+
+func TestParseSct(t *testing.T) {
+	buf := make([]byte, ata_log_page)
+	buf[0] = 3                                  // format version
+	buf[200], buf[203], buf[204] = 35, 0xfb, 61 // current, lifetime min -5, lifetime max
+	buf[206] = 2                                // over limit count
+	status, err := ParseSctStatus(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if status.Temperature != 35 || status.LifetimeMin != -5 || status.LifetimeMax != 61 || status.OverLimitCount != 2 {
+		t.Errorf("incorrect value %+v", status)
+	}
+
+	buf = make([]byte, ata_log_page)
+	buf[4] = 1                // interval
+	buf[6], buf[9] = 70, 0xfb // max operating, under limit -5
+	buf[30], buf[32] = 4, 1   // 4 entries, index 1
+	copy(buf[34:], []byte{40, 42, 0x80, 30})
+	h := ParseSctTemperatureHistory(buf)
+	if h.Interval != 1 || h.MaxOperating != 70 || h.UnderLimit != -5 || !slices.Equal(h.Samples, []int8{30, 40, 42}) {
+		t.Errorf("incorrect value %+v", h)
+	}
+}
+
+func TestParseSataPhyEvents(t *testing.T) {
+	buf := make([]byte, ata_log_page)
+	copy(buf[4:], []byte{0x01, 0x10, 0x05, 0x00})                 // command failed with ICRC, 16 bit
+	copy(buf[8:], []byte{0x0a, 0x20, 0x02, 0x01, 0x00, 0x00})     // COMRESET, 32 bit
+	copy(buf[14:], []byte{0x01, 0xc0, 0x07, 0, 0, 0, 0, 0, 0, 0}) // vendor specific, 64 bit
+	v := ParseSataPhyEvents(buf)
+	if len(v) != 3 || v["command_failed_icrc"] != 5 || v["d2h_register_fis_comreset"] != 0x102 || v["vendor_0x001"] != 7 {
+		t.Errorf("incorrect value %v", v)
+	}
+}
+
+func TestParseNvmeErrorLog(t *testing.T) {
+	buf := make([]byte, 2*nvme_error_entry_size)
+	buf[0] = 7                    // error count
+	buf[8] = 1                    // sqid
+	buf[10] = 0x1c                // cid
+	buf[12], buf[13] = 0x02, 0x05 // sc 0x81, sct 0x2
+	entries := parseNvmeErrorLog(buf)
+	if len(entries) != 1 {
+		t.Errorf("incorrect entries %v", entries)
+		return
+	}
+	e := entries[0]
+	if e.ErrorCount != 7 || e.SQID != 1 || e.CID != 0x1c || e.SC != 0x81 || e.SCT != 0x2 {
+		t.Errorf("incorrect value %+v", e)
+	}
+}
+
+func TestParseNvmeFirmwareSlots(t *testing.T) {
+	buf := make([]byte, nvme_firmware_slot_log_size)
+	buf[0] = 0x31 // running slot 1, slot 3 at next reset
+	f := parseNvmeFirmwareSlots(buf)
+	if f.State(1) != "active" || f.State(2) != "inactive" || f.State(3) != "pending" {
+		t.Errorf("incorrect states %s %s %s", f.State(1), f.State(2), f.State(3))
+	}
+	buf[0] = 0x22 // the running slot is also activated at next reset
+	f = parseNvmeFirmwareSlots(buf)
+	if f.State(2) != "active_pending" || f.State(1) != "inactive" {
+		t.Errorf("incorrect states %s %s", f.State(2), f.State(1))
+	}
+}
+
+func TestParseNvmePersistentEvents(t *testing.T) {
+	buf := make([]byte, nvme_pel_header_size+2*nvme_pel_event_head+8)
+	buf[4] = 2 // total number of events
+	first := buf[nvme_pel_header_size:]
+	first[0] = 0x04 // power-on or reset
+	first[2] = 21   // event header length
+	first[6], first[7] = 0xe8, 0x03
+	first[12] = 0xff // timestamp attributes above bit 47
+	first[22] = 8    // event length
+	second := buf[nvme_pel_header_size+nvme_pel_event_head+8:]
+	second[0] = 0x0d // thermal excursion
+	second[2] = 21
+	events := ParseNvmePersistentEvents(buf[nvme_pel_header_size:], int(buf[4]))
+	if len(events) != 2 {
+		t.Errorf("incorrect events %v", events)
+		return
+	}
+	if events[0].Type != 0x04 || events[0].Timestamp != 1000 || events[1].Type != 0x0d || events[1].Timestamp != 0 {
+		t.Errorf("incorrect value %+v", events)
+	}
+	if len(events[0].Data) != nvme_pel_event_head+8 || len(events[1].Data) != nvme_pel_event_head {
+		t.Errorf("incorrect event sizes %d %d", len(events[0].Data), len(events[1].Data))
+	}
+	// an event cut off at the end of the read is left for the next one
+	if events := ParseNvmePersistentEvents(buf[nvme_pel_header_size:len(buf)-1], 2); len(events) != 1 {
+		t.Errorf("incorrect events %v", events)
+	}
+}
+
+// pelLog builds a persistent event log of events without event data, the timestamp
+// tells them apart
+func pelLog(generation uint16, events ...[2]uint8) (header, log []byte) {
+	log = make([]byte, nvme_pel_header_size)
+	for _, e := range events {
+		head := make([]byte, nvme_pel_event_head)
+		head[0], head[2], head[6] = e[0], 21, e[1]
+		log = append(log, head...)
+	}
+	binary.LittleEndian.PutUint32(log[4:8], uint32(len(events)))
+	binary.LittleEndian.PutUint64(log[8:16], uint64(len(log)))
+	binary.LittleEndian.PutUint16(log[372:374], generation)
+	return log[:nvme_pel_header_size], log
+}
+
+func TestCountPersistentEvents(t *testing.T) {
+	d := &NvmeDev{pel_events: make(map[uint8]uint64), pel_last: make(map[uint8]uint64)}
+	steps := []struct {
+		events   [][2]uint8
+		power_on uint64
+		thermal  uint64
+	}{
+		{[][2]uint8{{0x04, 1}, {0x0d, 2}}, 1, 1},
+		{[][2]uint8{{0x04, 1}, {0x0d, 2}}, 1, 1},
+		// the full log drops its oldest event for a new one at the same length
+		{[][2]uint8{{0x0d, 2}, {0x04, 3}}, 2, 1},
+		{[][2]uint8{{0x0d, 2}, {0x04, 3}, {0x0d, 4}}, 2, 2},
+	}
+	for i, step := range steps {
+		header, log := pelLog(1, step.events...)
+		err := d.countPersistentEvents(header, func(start, end uint64) ([]byte, error) {
+			return log[start:end], nil
+		})
+		if err != nil || d.pel_events[0x04] != step.power_on || d.pel_events[0x0d] != step.thermal {
+			t.Errorf("step %d: incorrect counts %v %v", i, d.pel_events, err)
+		}
+	}
+	// a new generation is a cleared log, its events are all new
+	header, log := pelLog(2, [2]uint8{0x0d, 5})
+	d.countPersistentEvents(header, func(start, end uint64) ([]byte, error) { return log[start:end], nil })
+	if d.pel_events[0x0d] != 3 || d.pel_last[0x0d] != 5 {
+		t.Errorf("incorrect counts after clear %v %v", d.pel_events, d.pel_last)
+	}
+}
+
+func TestParseOcpSmartLog(t *testing.T) {
+	buf := make([]byte, nvme_ocp_smart_log_size)
+	buf[32], buf[33] = 0x10, 0x01 // bad user nand blocks raw 0x110
+	buf[38] = 100                 // bad user nand blocks normalized
+	buf[97] = 1                   // thermal throttling status
+	buf[136] = 5                  // unaligned io, only in version 3
+	copy(buf[496:], nvme_ocp_smart_guid)
+	v := ParseOcpSmartLog(buf)
+	if v["bad_user_nand_blocks_raw"].Value != 0x110 || v["bad_user_nand_blocks_normalized"].Value != 100 || v["thermal_throttling_status"].Value != 1 {
+		t.Errorf("incorrect value %v", v)
+	}
+	if _, ok := v["unaligned_io"]; ok {
+		t.Errorf("unaligned_io should not be available in version 0")
+	}
+}
+
+func TestParseNvmeEnduranceGroupLog(t *testing.T) {
+	buf := make([]byte, nvme_endurance_group_log_size)
+	buf[5] = 12                // percent used
+	buf[64], buf[72] = 0x10, 1 // data units written 2^64 + 16
+	buf[128] = 3               // media errors
+	v := ParseNvmeEnduranceGroupLog(buf)
+	if v["percent_used"].Value != 12 || v["data_units_written"].Value != 1<<64+16 || v["media_errors"].Value != 3 {
+		t.Errorf("incorrect value %v", v)
+	}
+}
+
+func TestParseIntelSmartLog(t *testing.T) {
+	buf := make([]byte, nvme_intel_smart_log_size)
+	copy(buf, []byte{0xad, 0, 0, 100, 0, 0x10, 0x00, 0x20, 0x00, 0x18, 0x00, 0})
+	attrs := ParseIntelSmartLog(buf)
+	if len(attrs) != 1 || attrs[0].Id != 0xad || attrs[0].Normalized != 100 || attrs[0].Raw[2] != 0x20 {
+		t.Errorf("incorrect value %v", attrs)
+	}
+}
+
+func TestParseMicronExtSmartLog(t *testing.T) {
+	buf := make([]byte, nvme_micron_ext_smart_log_size)
+	buf[12] = 3                     // grown bad blocks
+	buf[168], buf[169] = 0x00, 0x10 // total bytes written
+	buf[244] = 50                   // user block max erase count
+	v := ParseMicronExtSmartLog(buf)
+	if v["grown_bad_blocks"].Value != 3 || v["total_bytes_written"].Value != 0x1000 || v["user_block_erase_count_max"].Value != 50 {
+		t.Errorf("incorrect value %v", v)
+	}
+	if v["total_bytes_written"].Type != prometheus.CounterValue {
+		t.Errorf("incorrect type %v", v["total_bytes_written"])
+	}
+}
+
+func TestParseWdcPerfStats(t *testing.T) {
+	buf := make([]byte, 64+wdc_subpage_head+wdc_perf_stats_count*8)
+	buf[0] = 2 // subpages
+	// interval statistics are skipped, only lifetime ones are read
+	copy(buf[wdc_log_header_size:], []byte{wdc_subpage_perf, 0x01, 56, 0})
+	lifetime := buf[wdc_log_header_size+wdc_subpage_head+56:]
+	copy(lifetime, []byte{wdc_subpage_perf, wdc_perf_lifetime, wdc_perf_stats_count * 8, 0})
+	lifetime[wdc_subpage_head] = 9      // host read commands
+	lifetime[wdc_subpage_head+14*8] = 2 // nand read before write
+	v := ParseWdcPerfStats(buf)
+	if len(v) != wdc_perf_stats_count || v["host_read_commands"] != 9 || v["nand_read_before_write"] != 2 {
+		t.Errorf("incorrect value %v", v)
+	}
+}
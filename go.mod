@@ -19,5 +19,5 @@ require (
 
 require (
 	github.com/prometheus/client_golang v1.18.0
-	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/sys v0.15.0
 )
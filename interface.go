@@ -7,6 +7,10 @@ import (
 
 type PromDev interface {
 	Name() string
+	Type() string
+	// Health tells if the device considers itself healthy, reason is the raw status
+	// the verdict is based on
+	Health() (ok bool, reason string, err error)
 	ListMetrics() map[string]*prometheus.Desc
 	GetMetrics() []PromValue
 	Close() error
@@ -20,7 +24,7 @@ type PromValue struct {
 }
 
 func NewPromDev(name string) (d PromDev, err error) {
-	dev, err := smart.Open("/dev/" + name)
+	dev, err := smart.Open(devPath(name))
 	if err != nil {
 		return
 	}
@@ -28,8 +32,18 @@ func NewPromDev(name string) (d PromDev, err error) {
 	case *smart.SataDevice:
 		d = NewSataDev(name, sm)
 	case *smart.ScsiDevice:
+		d = NewScsiDev(name, sm)
 	case *smart.NVMeDevice:
 		d = NewNvmeDev(name, sm)
 	}
 	return
 }
+
+// SelfTester is implemented by devices able to start a self-test, kind is one of
+// "short" and "extended"
+type SelfTester interface {
+	StartSelfTest(kind string) error
+	// SelfTestAge returns the power-on hours since the last logged test of kind that
+	// ran to the end, ok is false if the log has none
+	SelfTestAge(kind string) (hours uint64, ok bool, err error)
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"log/slog"
 	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -14,6 +15,10 @@ var (
 	metrics   string
 	sys       string
 	listen    string
+	selftest  string
+	drivedb   string
+	short     time.Duration
+	extended  time.Duration
 	skip_devs arrayFlags
 	help      bool
 )
@@ -33,6 +38,10 @@ func main() {
 	flag.StringVar(&metrics, "m", "/metrics", "set metrics path")
 	flag.StringVar(&sys, "s", "", "set system metrics path")
 	flag.StringVar(&listen, "l", ":8188", "set listen address")
+	flag.StringVar(&selftest, "selftest", "", "set self-test trigger path, disabled if empty")
+	flag.DurationVar(&short, "selftest-short", 0, "set power-on time between scheduled short self-tests, e.g. 24h, disabled if 0")
+	flag.DurationVar(&extended, "selftest-extended", 0, "set power-on time between scheduled extended self-tests, e.g. 168h, disabled if 0")
+	flag.StringVar(&drivedb, "drivedb", "", "set path of smartmontools drivedb.h for sata attribute names and raw formats")
 	flag.Var(&skip_devs, "skip", "set skipped devs")
 	flag.BoolVar(&help, "h", false, "show help")
 	flag.Parse()
@@ -41,17 +50,34 @@ func main() {
 		return
 	}
 
+	if len(drivedb) != 0 {
+		db, err := LoadDriveDb(drivedb)
+		if err != nil {
+			slog.Error("failed to load drive database", "path", drivedb, "err", err)
+			return
+		}
+		drive_db = db
+	}
+
 	r := prometheus.NewRegistry()
 	col := NewCollector(skip_devs...)
 	defer col.Close()
 	r.MustRegister(col)
 	handler := promhttp.HandlerFor(r, promhttp.HandlerOpts{})
 	server := NewHttpServer()
-	SignalsCallback(func() { server.Shutdown(context.Background()) }, true, syscall.SIGINT, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	SignalsCallback(func() {
+		cancel()
+		server.Shutdown(context.Background())
+	}, true, syscall.SIGINT, syscall.SIGTERM)
+	go ScheduleSelfTests(ctx, col, short, extended)
 	server.Handle(metrics, handler)
 	if len(sys) != 0 {
 		server.Handle(sys, promhttp.Handler())
 	}
+	if len(selftest) != 0 {
+		server.Handle(selftest, SelfTestHandler(col))
+	}
 	err := server.ListenAndServe(listen)
 	if err != nil {
 		slog.Error("http server exit with error", "err", err)
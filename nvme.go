@@ -3,27 +3,65 @@ package main
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
 	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/anatol/smart.go"
 	"github.com/dustin/go-humanize"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
 )
 
 type NvmeDev struct {
 	name    string
 	dev     *smart.NVMeDevice
+	fd      int
 	info    []string
 	ns_info [][]string
+	nsids   []uint32   // active namespaces, in the order of ns_info
+	endgids []uint16   // endurance groups of the active namespaces
+	ns_devs [][]string // block devices of the namespaces, when opened as a controller
+	tnvmcap float64
+	unvmcap float64
+	ocp     bool   // OCP SMART / Health Information Extended log is available
+	elpe    uint8  // error log page entries, 0's based
+	lpa     uint8  // log page attributes
+	oacs    uint16 // optional admin command support
+	wctemp  uint16 // warning composite temperature threshold in kelvin, 0 if not reported
+	cctemp  uint16 // critical composite temperature threshold in kelvin, 0 if not reported
+	psd     []smart.NvmeIdentPowerState
+	vendor  nvmeVendorDecoder // nil if there is none for the PCI vendor ID
+
+	// error log entries seen in previous scrapes, guarded by mu
+	mu               sync.Mutex
+	last_error_count uint64
+	error_status     map[[2]uint8]uint64
+
+	// persistent events seen in previous scrapes and the position in the log after
+	// the last of them, guarded by mu
+	pel_read       bool
+	pel_generation uint16
+	pel_length     uint64 // offset after the last counted event
+	pel_tail       []byte // the last counted event, to find it again after older ones are deleted
+	pel_events     map[uint8]uint64
+	pel_last       map[uint8]uint64
 }
 
 const (
 	metric_head                = "smart_"
 	metric_nvme                = metric_head + "nvme_"
 	nvmeCritWarning            = metric_nvme + "CritWarning"
-	nvmeTemperature            = metric_nvme + "Temperature"
+	nvmeTemperature            = metric_nvme + "temperature_celsius"
 	nvmeAvailSpare             = metric_nvme + "AvailSpare"
 	nvmeSpareThresh            = metric_nvme + "SpareThresh"
 	nvmePercentUsed            = metric_nvme + "PercentUsed"
@@ -40,11 +78,38 @@ const (
 	nvmeNumErrLogEntries       = metric_nvme + "NumErrLogEntries"
 	nvmeWarningTempTime        = metric_nvme + "WarningTempTime"
 	nvmeCritCompTime           = metric_nvme + "CritCompTime"
-	nvmeTempSensor             = metric_nvme + "TempSensor"
+	nvmeTempSensor             = metric_nvme + "temperature_sensor_celsius"
 	nvmeThermalTransitionCount = metric_nvme + "ThermalTransitionCount"
 	nvmeThermalManagementTime  = metric_nvme + "ThermalManagementTime"
 	nvmeInfo                   = metric_nvme + "Info"
 	nvmeNamespaceInfo          = metric_nvme + "NamespaceInfo"
+	nvmeCriticalWarningBit     = metric_nvme + "critical_warning"
+	nvmeEnduranceWarningBit    = metric_nvme + "endurance_critical_warning"
+	nvmeErrorLogStatus         = metric_nvme + "error_log_status_total"
+	nvmeErrorLogLatestSct      = metric_nvme + "error_log_latest_sct"
+	nvmeErrorLogLatestSc       = metric_nvme + "error_log_latest_sc"
+	nvmeErrorLogLatestSqid     = metric_nvme + "error_log_latest_sqid"
+	nvmeErrorLogLatestCid      = metric_nvme + "error_log_latest_cid"
+	nvmeErrorLogLatestLba      = metric_nvme + "error_log_latest_lba"
+	nvmeSelfTestOperation      = metric_nvme + "self_test_current_operation"
+	nvmeSelfTestCompletion     = metric_nvme + "self_test_current_completion"
+	nvmeSelfTestLastResult     = metric_nvme + "self_test_last_result"
+	nvmeSelfTestLastHours      = metric_nvme + "self_test_last_power_on_hours"
+	nvmeFirmwareActiveSlot     = metric_nvme + "firmware_active_slot"
+	nvmeFirmwarePendingSlot    = metric_nvme + "firmware_pending_slot"
+	nvmeFirmwareSlotInfo       = metric_nvme + "firmware_slot_info"
+	nvmeTemperatureWarning     = metric_nvme + "temperature_warning_celsius"
+	nvmeTemperatureCritical    = metric_nvme + "temperature_critical_celsius"
+	nvmePowerStateMaxWatts     = metric_nvme + "power_state_max_watts"
+	nvmePowerStateEntryLatency = metric_nvme + "power_state_entry_latency_seconds"
+	nvmePowerStateExitLatency  = metric_nvme + "power_state_exit_latency_seconds"
+	nvmeCurrentPowerState      = metric_nvme + "current_power_state"
+	nvmeTotalCapacity          = metric_nvme + "total_capacity_bytes"
+	nvmeUnallocatedCapacity    = metric_nvme + "unallocated_capacity_bytes"
+	nvmeNamespaceSize          = metric_nvme + "namespace_size_bytes"
+	nvmeNamespaceCapacity      = metric_nvme + "namespace_capacity_bytes"
+	nvmeNamespaceUtilization   = metric_nvme + "namespace_utilization_bytes"
+	nvmeNamespaceDevice        = metric_nvme + "namespace_device_info"
 	tag_dev                    = "dev"
 )
 
@@ -71,7 +136,29 @@ var (
 		"Formatted_LBA_Size",
 		"IEEE_EUI_64",
 	}
-	nvme_metrics = list_nvme_metrics()
+	tags_dev_bit          = []string{tag_dev, "bit"}
+	tags_nvme_status      = []string{tag_dev, "sct", "sc"}
+	tags_nvme_namespace   = []string{tag_dev, "namespace"}
+	tags_nvme_ns_device   = []string{tag_dev, "namespace", "device"}
+	tags_nvme_power_state = []string{tag_dev, "state"}
+	tags_nvme_fw_slot     = []string{tag_dev, "slot", "revision", "state"}
+	nvme_metrics          = list_nvme_metrics()
+
+	// bits of the critical warning field in the SMART / Health log
+	nvme_critical_warning_bits = []string{
+		"spare",
+		"temperature",
+		"reliability",
+		"readonly",
+		"volatile_backup",
+		"pmr_readonly",
+	}
+	// bits of the endurance group critical warning summary, the rest are reserved
+	nvme_endurance_warning_bits = map[int]string{
+		0: "spare",
+		2: "reliability",
+		3: "readonly",
+	}
 )
 
 func list_nvme_metrics() (out map[string]*prometheus.Desc) {
@@ -95,6 +182,20 @@ func list_nvme_metrics() (out map[string]*prometheus.Desc) {
 		nvmeNumErrLogEntries,
 		nvmeWarningTempTime,
 		nvmeCritCompTime,
+		nvmeErrorLogLatestSct,
+		nvmeErrorLogLatestSc,
+		nvmeErrorLogLatestSqid,
+		nvmeErrorLogLatestCid,
+		nvmeErrorLogLatestLba,
+		nvmeSelfTestOperation,
+		nvmeSelfTestCompletion,
+		nvmeFirmwareActiveSlot,
+		nvmeFirmwarePendingSlot,
+		nvmeTemperatureWarning,
+		nvmeTemperatureCritical,
+		nvmeCurrentPowerState,
+		nvmeTotalCapacity,
+		nvmeUnallocatedCapacity,
 	}
 	for _, metric_name := range normal_metrics {
 		out[metric_name] = prometheus.NewDesc(metric_name, "", tags_dev_only, nil)
@@ -109,15 +210,72 @@ func list_nvme_metrics() (out map[string]*prometheus.Desc) {
 		out[metric_name] = prometheus.NewDesc(metric_name, "", tags_dev_index, nil)
 	}
 
+	out[nvmeCriticalWarningBit] = prometheus.NewDesc(nvmeCriticalWarningBit, "", tags_dev_bit, nil)
+	out[nvmeEnduranceWarningBit] = prometheus.NewDesc(nvmeEnduranceWarningBit, "", tags_dev_bit, nil)
+	out[nvmeSelfTestLastResult] = prometheus.NewDesc(nvmeSelfTestLastResult, "", tags_dev_type, nil)
+	out[nvmeSelfTestLastHours] = prometheus.NewDesc(nvmeSelfTestLastHours, "", tags_dev_type, nil)
+	power_state_metrics := []string{
+		nvmePowerStateMaxWatts,
+		nvmePowerStateEntryLatency,
+		nvmePowerStateExitLatency,
+	}
+	for _, metric_name := range power_state_metrics {
+		out[metric_name] = prometheus.NewDesc(metric_name, "", tags_nvme_power_state, nil)
+	}
+
+	namespace_metrics := []string{
+		nvmeNamespaceSize,
+		nvmeNamespaceCapacity,
+		nvmeNamespaceUtilization,
+	}
+	for _, metric_name := range namespace_metrics {
+		out[metric_name] = prometheus.NewDesc(metric_name, "", tags_nvme_namespace, nil)
+	}
+
+	out[nvmeFirmwareSlotInfo] = prometheus.NewDesc(nvmeFirmwareSlotInfo, "", tags_nvme_fw_slot, nil)
+	out[nvmeErrorLogStatus] = prometheus.NewDesc(nvmeErrorLogStatus, "", tags_nvme_status, nil)
+	out[nvmeNamespaceDevice] = prometheus.NewDesc(nvmeNamespaceDevice, "", tags_nvme_ns_device, nil)
+	maps.Copy(out, list_nvme_pel_metrics())
+	maps.Copy(out, list_nvme_endurance_metrics())
+	maps.Copy(out, list_nvme_ocp_metrics())
+	maps.Copy(out, list_nvme_vendor_metrics())
 	out[nvmeInfo] = prometheus.NewDesc(nvmeInfo, "", tags_nvme_info, nil)
 	out[nvmeNamespaceInfo] = prometheus.NewDesc(nvmeNamespaceInfo, "", tags_nvme_namespace_info, nil)
 	return
 }
 
-func NewNvmeDev(name string, smartdev *smart.NVMeDevice) (d *NvmeDev) {
-	d = &NvmeDev{name, smartdev, nil, nil}
-	id, nss, err := d.dev.Identify()
-	if err == nil {
+func NewNvmeDev(name string, smartdev *smart.NVMeDevice) *NvmeDev {
+	id, nss, err := smartdev.Identify()
+	return newNvmeDev(name, smartdev, id, nss, err)
+}
+
+// newNvmeDev builds the dev from the result of Identify, which the caller already sent
+func newNvmeDev(name string, smartdev *smart.NVMeDevice, id *smart.NvmeIdentController, nss []smart.NvmeIdentNamespace, id_err error) (d *NvmeDev) {
+	d = &NvmeDev{
+		name:         name,
+		dev:          smartdev,
+		error_status: make(map[[2]uint8]uint64),
+		pel_events:   make(map[uint8]uint64),
+		pel_last:     make(map[uint8]uint64),
+	}
+	var err error
+	d.fd, err = openRaw(devPath(name))
+	if err != nil {
+		slog.Warn("failed to open nvme dev, extra log pages are not available", "dev", name, "err", err)
+		d.fd = -1
+	}
+	if id_err == nil {
+		d.elpe = id.Elpe
+		d.lpa = id.Lpa
+		d.oacs = id.Oacs
+		d.wctemp = id.Wctemp
+		d.cctemp = id.Cctemp
+		d.psd = id.Psd[:min(int(id.Npss)+1, len(id.Psd))]
+		d.tnvmcap = Uint128toFloat64(id.Tnvmcap)
+		d.unvmcap = Uint128toFloat64(id.Unvmcap)
+		d.nsids, d.endgids = d.activeNamespaces(id.Nn)
+		_, d.ocp = d.readOcpSmartLog()
+		d.vendor = nvme_vendor_decoders[id.VendorID]
 		d.info = []string{
 			name,
 			id.ModelNumber(),                        // Model_Number
@@ -147,11 +305,77 @@ func NewNvmeDev(name string, smartdev *smart.NVMeDevice) (d *NvmeDev) {
 	return
 }
 
+// NewNvmeController opens the controller character device name (nvme0) instead of one
+// of its namespaces, so the controller wide logs are read once. namespaces are the
+// block devices of the controller, reported in the namespace device info metric.
+func NewNvmeController(name string, namespaces []string) (d *NvmeDev, err error) {
+	dev, err := smart.OpenNVMe(devPath(name))
+	if err != nil {
+		return
+	}
+	id, nss, err := dev.Identify()
+	if err != nil {
+		dev.Close()
+		return
+	}
+	d = newNvmeDev(name, dev, id, nss, nil)
+	for _, ns := range namespaces {
+		nsid, err := nvmeNamespaceId(ns)
+		if err != nil {
+			slog.Debug("failed to get namespace id", "dev", ns, "err", err)
+			continue
+		}
+		index := slices.Index(d.nsids, nsid)
+		if index < 0 {
+			continue
+		}
+		d.ns_devs = append(d.ns_devs, []string{name, strconv.Itoa(index), ns})
+	}
+	return
+}
+
+var nvme_controller_regexp = regexp.MustCompile(`^nvme\d+$`)
+
+// nvmeController returns the controller a namespace block device belongs to. Namespaces
+// of multipath subsystems have no single controller and are not grouped.
+func nvmeController(block string) (string, bool) {
+	link, err := os.Readlink(filepath.Join("/sys/block", block, "device"))
+	if err != nil {
+		return "", false
+	}
+	ctrl := filepath.Base(link)
+	return ctrl, nvme_controller_regexp.MatchString(ctrl)
+}
+
+func nvmeNamespaceId(block string) (uint32, error) {
+	b, err := os.ReadFile(filepath.Join("/sys/block", block, "nsid"))
+	if err != nil {
+		return 0, err
+	}
+	nsid, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 32)
+	return uint32(nsid), err
+}
+
 func (d *NvmeDev) Name() string {
 	return d.name
 }
 
+func (d *NvmeDev) Type() string {
+	return d.dev.Type()
+}
+
+func (d *NvmeDev) Health() (ok bool, reason string, err error) {
+	info, err := d.dev.ReadSMART()
+	if err != nil {
+		return
+	}
+	return info.CritWarning == 0, fmt.Sprintf("CritWarning 0x%02x", info.CritWarning), nil
+}
+
 func (d *NvmeDev) Close() error {
+	if d.fd >= 0 {
+		unix.Close(d.fd)
+	}
 	return d.dev.Close()
 }
 
@@ -209,15 +433,20 @@ func (d *NvmeDev) GetMetrics() (out []PromValue) {
 		i++
 	}
 
+	// all temperatures are reported in kelvin
 	template.Desc = nvme_metrics[nvmeTemperature]
-	template.Value = float64(info.Temperature)
+	template.Value = KelvinToCelsius(info.Temperature)
 	out[i] = template
 	i++
 
 	template.Desc = nvme_metrics[nvmeTempSensor]
 	for index, v := range info.TempSensor {
+		// sensor not implemented
+		if v == 0 {
+			continue
+		}
 		template.Tags = []string{d.name, strconv.Itoa(index)}
-		template.Value = float64(v)
+		template.Value = KelvinToCelsius(v)
 		out[i] = template
 		i++
 	}
@@ -262,6 +491,350 @@ func (d *NvmeDev) GetMetrics() (out []PromValue) {
 		out[i] = template
 		i++
 	}
+	out = out[:i]
+
+	template.Desc = nvme_metrics[nvmeNamespaceDevice]
+	for _, ns := range d.ns_devs {
+		template.Tags = ns
+		out = append(out, template)
+	}
+
+	template.Tags = []string{d.name}
+	thresholds := map[string]uint16{
+		nvmeTemperatureWarning:  d.wctemp,
+		nvmeTemperatureCritical: d.cctemp,
+	}
+	for name, value := range thresholds {
+		if value == 0 {
+			continue
+		}
+		template.Desc = nvme_metrics[name]
+		template.Value = KelvinToCelsius(value)
+		out = append(out, template)
+	}
+
+	template.Desc = nvme_metrics[nvmeCriticalWarningBit]
+	for bit, name := range nvme_critical_warning_bits {
+		template.Tags = []string{d.name, name}
+		template.Value = float64(info.CritWarning >> bit & 1)
+		out = append(out, template)
+	}
+	template.Desc = nvme_metrics[nvmeEnduranceWarningBit]
+	for bit, name := range nvme_endurance_warning_bits {
+		template.Tags = []string{d.name, name}
+		template.Value = float64(info.EnduranceCritWarning >> bit & 1)
+		out = append(out, template)
+	}
+
+	out = append(out, d.errorLogMetrics()...)
+	out = append(out, d.selfTestMetrics()...)
+	out = append(out, d.firmwareSlotMetrics()...)
+	out = append(out, d.powerStateMetrics()...)
+	out = append(out, d.capacityMetrics()...)
+	out = append(out, d.enduranceGroupMetrics()...)
+	out = append(out, d.persistentEventMetrics()...)
+	out = append(out, d.telemetryMetrics()...)
+	out = append(out, d.ocpMetrics()...)
+	if d.vendor != nil {
+		out = append(out, d.vendor.GetMetrics(d)...)
+	}
+	return
+}
+
+// activeNamespaces lists the namespaces smart.go returns from Identify, which skips
+// the ones with a size of 0
+func (d *NvmeDev) activeNamespaces(nn uint32) (nsids []uint32, endgids []uint16) {
+	if d.fd < 0 {
+		return
+	}
+	for nsid := uint32(1); nsid <= nn; nsid++ {
+		ns, err := nvmeIdentifyNamespace(d.fd, nsid)
+		if err != nil {
+			slog.Debug("failed to identify namespace", "dev", d.name, "nsid", nsid, "err", err)
+			return
+		}
+		if ns.Nsze == 0 {
+			continue
+		}
+		nsids = append(nsids, nsid)
+		if ns.Endgid != 0 && !slices.Contains(endgids, ns.Endgid) {
+			endgids = append(endgids, ns.Endgid)
+		}
+	}
+	return
+}
+
+// capacityMetrics exports controller capacity and size, capacity and utilization of
+// every namespace in bytes. Namespaces are identified again as utilization changes.
+func (d *NvmeDev) capacityMetrics() (out []PromValue) {
+	template := PromValue{
+		Type: prometheus.GaugeValue,
+		Tags: []string{d.name},
+	}
+	if d.tnvmcap != 0 {
+		template.Desc = nvme_metrics[nvmeTotalCapacity]
+		template.Value = d.tnvmcap
+		out = append(out, template)
+		template.Desc = nvme_metrics[nvmeUnallocatedCapacity]
+		template.Value = d.unvmcap
+		out = append(out, template)
+	}
+
+	for i, nsid := range d.nsids {
+		ns, err := nvmeIdentifyNamespace(d.fd, nsid)
+		if err != nil {
+			slog.Debug("failed to identify namespace", "dev", d.name, "nsid", nsid, "err", err)
+			continue
+		}
+		lba := float64(ns.LbaSize())
+		template.Tags = []string{d.name, strconv.Itoa(i)}
+		ns_metrics := map[string]float64{
+			nvmeNamespaceSize:        float64(ns.Nsze) * lba,
+			nvmeNamespaceCapacity:    float64(ns.Ncap) * lba,
+			nvmeNamespaceUtilization: float64(ns.Nuse) * lba,
+		}
+		for name, value := range ns_metrics {
+			template.Desc = nvme_metrics[name]
+			template.Value = value
+			out = append(out, template)
+		}
+	}
+	return
+}
+
+// powerStateMetrics exports the power state descriptors from Identify and the
+// current power state from Get Features
+func (d *NvmeDev) powerStateMetrics() (out []PromValue) {
+	template := PromValue{
+		Type: prometheus.GaugeValue,
+	}
+	for state, ps := range d.psd {
+		template.Tags = []string{d.name, strconv.Itoa(state)}
+		ps_metrics := map[string]float64{
+			nvmePowerStateMaxWatts:     PowerStateMaxWatts(ps),
+			nvmePowerStateEntryLatency: float64(ps.EntryLat) / 1e6, // microseconds
+			nvmePowerStateExitLatency:  float64(ps.ExitLat) / 1e6,
+		}
+		for name, value := range ps_metrics {
+			template.Desc = nvme_metrics[name]
+			template.Value = value
+			out = append(out, template)
+		}
+	}
+
+	if d.fd < 0 {
+		return
+	}
+	result, err := nvmeAdmin(d.fd, nvmePassthruCmd64{
+		opcode: nvme_admin_get_features,
+		cdw10:  nvme_feature_power_management, // SEL = 0, current value
+	}, nil)
+	if err != nil {
+		slog.Debug("failed to get power state", "dev", d.name, "err", err)
+		return
+	}
+	template.Desc = nvme_metrics[nvmeCurrentPowerState]
+	template.Tags = []string{d.name}
+	template.Value = float64(result & 0x1f)
+	out = append(out, template)
+	return
+}
+
+// PowerStateMaxWatts converts max power of ps, which is in 0.01W or 0.0001W units
+// depending on the max power scale bit
+func PowerStateMaxWatts(ps smart.NvmeIdentPowerState) float64 {
+	if ps.Flags&0x1 != 0 {
+		return float64(ps.MaxPower) / 10000
+	}
+	return float64(ps.MaxPower) / 100
+}
+
+// firmwareSlotMetrics exports the revision of every slot, state label tells which
+// slot is running and which one will be activated at the next reset
+func (d *NvmeDev) firmwareSlotMetrics() (out []PromValue) {
+	if d.fd < 0 {
+		return
+	}
+	buf := make([]byte, nvme_firmware_slot_log_size)
+	if err := nvmeGetLogPage(d.fd, nvme_nsid_all, nvme_log_firmware_slot, 0, 0, 0, buf); err != nil {
+		slog.Debug("failed to read firmware slot log", "dev", d.name, "err", err)
+		return
+	}
+	f := parseNvmeFirmwareSlots(buf)
+	template := PromValue{
+		Type: prometheus.GaugeValue,
+		Tags: []string{d.name},
+	}
+	template.Desc = nvme_metrics[nvmeFirmwareActiveSlot]
+	template.Value = float64(f.Active)
+	out = append(out, template)
+	template.Desc = nvme_metrics[nvmeFirmwarePendingSlot]
+	template.Value = float64(f.Pending)
+	out = append(out, template)
+
+	template.Desc = nvme_metrics[nvmeFirmwareSlotInfo]
+	template.Value = 0
+	for i, rev := range f.Revisions {
+		if len(rev) == 0 {
+			continue
+		}
+		slot := uint8(i + 1)
+		template.Tags = []string{d.name, strconv.Itoa(int(slot)), rev, f.State(slot)}
+		out = append(out, template)
+	}
+	return
+}
+
+// selfTestMetrics exports the test in progress and the latest result of each test type
+func (d *NvmeDev) selfTestMetrics() (out []PromValue) {
+	if d.fd < 0 {
+		return
+	}
+	buf := make([]byte, nvme_self_test_log_size)
+	if err := nvmeGetLogPage(d.fd, nvme_nsid_all, nvme_log_self_test, 0, 0, 0, buf); err != nil {
+		slog.Debug("failed to read self-test log", "dev", d.name, "err", err)
+		return
+	}
+	l := parseNvmeSelfTestLog(buf)
+	template := PromValue{
+		Type: prometheus.GaugeValue,
+		Tags: []string{d.name},
+	}
+	template.Desc = nvme_metrics[nvmeSelfTestOperation]
+	template.Value = float64(l.CurrentOperation)
+	out = append(out, template)
+	template.Desc = nvme_metrics[nvmeSelfTestCompletion]
+	template.Value = float64(l.CurrentCompletion)
+	out = append(out, template)
+
+	seen := make(map[uint8]bool)
+	for _, r := range l.Results {
+		typ, ok := nvme_self_test_types[r.Code]
+		if !ok || seen[r.Code] {
+			continue
+		}
+		seen[r.Code] = true
+		template.Tags = []string{d.name, typ}
+		template.Desc = nvme_metrics[nvmeSelfTestLastResult]
+		template.Value = float64(r.Result)
+		out = append(out, template)
+		template.Desc = nvme_metrics[nvmeSelfTestLastHours]
+		template.Value = float64(r.PowerOnHour)
+		out = append(out, template)
+	}
+	return
+}
+
+// SelfTestAge compares the power-on hours with the newest result of kind that was not
+// aborted
+func (d *NvmeDev) SelfTestAge(kind string) (hours uint64, ok bool, err error) {
+	if d.fd < 0 {
+		err = fmt.Errorf("nvme dev %s is not opened", d.name)
+		return
+	}
+	attrs, err := d.dev.ReadGenericAttributes()
+	if err != nil {
+		return
+	}
+	buf := make([]byte, nvme_self_test_log_size)
+	if err = nvmeGetLogPage(d.fd, nvme_nsid_all, nvme_log_self_test, 0, 0, 0, buf); err != nil {
+		return
+	}
+	for _, r := range parseNvmeSelfTestLog(buf).Results {
+		if nvme_self_test_types[r.Code] == kind && r.Completed() {
+			if attrs.PowerOnHours > r.PowerOnHour {
+				hours = attrs.PowerOnHours - r.PowerOnHour
+			}
+			return hours, true, nil
+		}
+	}
+	return
+}
+
+func (d *NvmeDev) StartSelfTest(kind string) error {
+	var code uint32
+	switch kind {
+	case "short":
+		code = nvme_self_test_short
+	case "extended":
+		code = nvme_self_test_extended
+	default:
+		return errUnknownTestKind
+	}
+	if d.fd < 0 {
+		return fmt.Errorf("nvme dev %s is not opened", d.name)
+	}
+	if d.oacs&nvme_oacs_self_test == 0 {
+		return errSelfTestNotSupported
+	}
+	_, err := nvmeAdmin(d.fd, nvmePassthruCmd64{
+		opcode: nvme_admin_dev_self_test,
+		nsid:   nvme_nsid_all,
+		cdw10:  code,
+	}, nil)
+	var status nvmeStatusError
+	if errors.As(err, &status) && status.SCT() == nvme_sct_command_specific && status.SC() == nvme_sc_self_test_in_progress {
+		return errSelfTestInProgress
+	}
+	return err
+}
+
+// errorLogMetrics reads the error information log. Every entry is counted once by
+// its error count, so entries still in the log are not counted again in later scrapes.
+func (d *NvmeDev) errorLogMetrics() (out []PromValue) {
+	if d.fd < 0 {
+		return
+	}
+	buf := make([]byte, (int(d.elpe)+1)*nvme_error_entry_size)
+	if err := nvmeGetLogPage(d.fd, nvme_nsid_all, nvme_log_error_info, 0, 0, 0, buf); err != nil {
+		slog.Debug("failed to read error log", "dev", d.name, "err", err)
+		return
+	}
+	entries := parseNvmeErrorLog(buf)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var latest *nvmeErrorEntry
+	max_count := d.last_error_count
+	for i, e := range entries {
+		if latest == nil || e.ErrorCount > latest.ErrorCount {
+			latest = &entries[i]
+		}
+		if e.ErrorCount <= d.last_error_count {
+			continue
+		}
+		d.error_status[[2]uint8{e.SCT, e.SC}]++
+		max_count = max(max_count, e.ErrorCount)
+	}
+	d.last_error_count = max_count
+
+	template := PromValue{
+		Desc: nvme_metrics[nvmeErrorLogStatus],
+		Type: prometheus.CounterValue,
+	}
+	for status, count := range d.error_status {
+		template.Tags = []string{d.name, fmt.Sprintf("0x%x", status[0]), fmt.Sprintf("0x%02x", status[1])}
+		template.Value = float64(count)
+		out = append(out, template)
+	}
+
+	if latest == nil {
+		return
+	}
+	template.Type = prometheus.GaugeValue
+	template.Tags = []string{d.name}
+	latest_metrics := map[string]uint64{
+		nvmeErrorLogLatestSct:  uint64(latest.SCT),
+		nvmeErrorLogLatestSc:   uint64(latest.SC),
+		nvmeErrorLogLatestSqid: uint64(latest.SQID),
+		nvmeErrorLogLatestCid:  uint64(latest.CID),
+		nvmeErrorLogLatestLba:  latest.LBA,
+	}
+	for name, value := range latest_metrics {
+		template.Desc = nvme_metrics[name]
+		template.Value = float64(value)
+		out = append(out, template)
+	}
 	return
 }
 
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Endurance Group Information log (09h), NVMe 1.4
+
+const (
+	metric_nvme_endurance = metric_nvme + "endurance_group_"
+
+	nvme_log_endurance_group      = 0x09
+	nvme_endurance_group_log_size = 512
+)
+
+var tags_nvme_endurance_group = []string{tag_dev, "endurance_group"}
+
+type nvmeEnduranceField struct {
+	name   string
+	offset int
+	size   int
+	typ    prometheus.ValueType
+}
+
+var nvme_endurance_fields = []nvmeEnduranceField{
+	{"critical_warning", 0, 1, prometheus.GaugeValue},
+	{"available_spare", 3, 1, prometheus.GaugeValue},
+	{"available_spare_threshold", 4, 1, prometheus.GaugeValue},
+	{"percent_used", 5, 1, prometheus.GaugeValue},
+	{"endurance_estimate", 32, 16, prometheus.GaugeValue},
+	{"data_units_read", 48, 16, prometheus.CounterValue},
+	{"data_units_written", 64, 16, prometheus.CounterValue},
+	{"media_units_written", 80, 16, prometheus.CounterValue},
+	{"host_read_commands", 96, 16, prometheus.CounterValue},
+	{"host_write_commands", 112, 16, prometheus.CounterValue},
+	{"media_errors", 128, 16, prometheus.CounterValue},
+	{"error_log_entries", 144, 16, prometheus.CounterValue},
+}
+
+func list_nvme_endurance_metrics() (out map[string]*prometheus.Desc) {
+	out = make(map[string]*prometheus.Desc)
+	for _, f := range nvme_endurance_fields {
+		out[metric_nvme_endurance+f.name] = prometheus.NewDesc(metric_nvme_endurance+f.name, "", tags_nvme_endurance_group, nil)
+	}
+	return
+}
+
+// ParseNvmeEnduranceGroupLog decodes the Endurance Group Information log
+func ParseNvmeEnduranceGroupLog(buf []byte) (out map[string]logValue) {
+	out = make(map[string]logValue)
+	if len(buf) < nvme_endurance_group_log_size {
+		return
+	}
+	for _, f := range nvme_endurance_fields {
+		field := buf[f.offset : f.offset+f.size]
+		out[f.name] = logValue{f.typ, leFloat(field)}
+	}
+	return
+}
+
+// enduranceGroupMetrics reads the log of every endurance group the active namespaces
+// belong to
+func (d *NvmeDev) enduranceGroupMetrics() (out []PromValue) {
+	if d.fd < 0 {
+		return
+	}
+	buf := make([]byte, nvme_endurance_group_log_size)
+	for _, endgid := range d.endgids {
+		if err := nvmeGetLogPage(d.fd, 0, nvme_log_endurance_group, 0, endgid, 0, buf); err != nil {
+			slog.Debug("failed to read endurance group log", "dev", d.name, "endurance_group", endgid, "err", err)
+			continue
+		}
+		tags := []string{d.name, strconv.Itoa(int(endgid))}
+		for name, v := range ParseNvmeEnduranceGroupLog(buf) {
+			out = append(out, PromValue{
+				Desc:  nvme_metrics[metric_nvme_endurance+name],
+				Type:  v.Type,
+				Value: v.Value,
+				Tags:  tags,
+			})
+		}
+	}
+	return
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/binary"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Intel (and Solidigm) additional SMART attributes log (CAh)
+
+const (
+	metric_nvme_intel = metric_nvme + "intel_"
+
+	nvme_log_intel_smart      = 0xca
+	nvme_intel_smart_log_size = 512
+	nvme_intel_entry_size     = 12
+
+	intelNormalized       = metric_nvme_intel + "normalized"
+	intelWearLevelingMin  = metric_nvme_intel + "wear_leveling_min"
+	intelWearLevelingMax  = metric_nvme_intel + "wear_leveling_max"
+	intelWearLevelingAvg  = metric_nvme_intel + "wear_leveling_avg"
+	intelMediaWear        = metric_nvme_intel + "timed_workload_media_wear_percent"
+	intelThrottlePercent  = metric_nvme_intel + "thermal_throttle_percent"
+	intelThrottleCount    = metric_nvme_intel + "thermal_throttle_count"
+	intelNandBytesWritten = metric_nvme_intel + "nand_bytes_written"
+	intelHostBytesWritten = metric_nvme_intel + "host_bytes_written"
+)
+
+// attributes with a plain counter as raw value
+var intel_counter_attributes = map[uint8]string{
+	0xab: "program_fail_count",
+	0xac: "erase_fail_count",
+	0xb8: "end_to_end_error_detection_count",
+	0xc7: "crc_error_count",
+	0xe4: "timed_workload_timer",
+	0xf0: "retry_buffer_overflow_count",
+	0xf3: "pll_lock_loss_count",
+}
+
+// attributes with a plain percentage as raw value
+var intel_gauge_attributes = map[uint8]string{
+	0xe3: "timed_workload_host_reads",
+}
+
+var intel_attribute_names = map[uint8]string{
+	0xad: "wear_leveling",
+	0xe2: "timed_workload_media_wear",
+	0xea: "thermal_throttle_status",
+	0xf4: "nand_bytes_written",
+	0xf5: "host_bytes_written",
+}
+
+var tags_dev_attribute = []string{tag_dev, "attribute"}
+
+type intelDecoder struct{}
+
+func (intelDecoder) ListMetrics() (out map[string]*prometheus.Desc) {
+	out = make(map[string]*prometheus.Desc)
+	names := []string{
+		intelWearLevelingMin,
+		intelWearLevelingMax,
+		intelWearLevelingAvg,
+		intelMediaWear,
+		intelThrottlePercent,
+		intelThrottleCount,
+		intelNandBytesWritten,
+		intelHostBytesWritten,
+	}
+	for _, attr := range intel_counter_attributes {
+		names = append(names, metric_nvme_intel+attr)
+	}
+	for _, attr := range intel_gauge_attributes {
+		names = append(names, metric_nvme_intel+attr)
+	}
+	for _, name := range names {
+		out[name] = prometheus.NewDesc(name, "", tags_dev_only, nil)
+	}
+	out[intelNormalized] = prometheus.NewDesc(intelNormalized, "", tags_dev_attribute, nil)
+	return
+}
+
+func (intelDecoder) GetMetrics(d *NvmeDev) (out []PromValue) {
+	if d.fd < 0 {
+		return
+	}
+	buf := make([]byte, nvme_intel_smart_log_size)
+	if err := nvmeGetLogPage(d.fd, nvme_nsid_all, nvme_log_intel_smart, 0, 0, 0, buf); err != nil {
+		slog.Debug("failed to read intel smart log", "dev", d.name, "err", err)
+		return
+	}
+	template := PromValue{
+		Type: prometheus.GaugeValue,
+		Tags: []string{d.name},
+	}
+	for _, attr := range ParseIntelSmartLog(buf) {
+		name, ok := intel_counter_attributes[attr.Id]
+		if !ok {
+			name, ok = intel_gauge_attributes[attr.Id]
+		}
+		if !ok {
+			name, ok = intel_attribute_names[attr.Id]
+		}
+		if !ok {
+			continue
+		}
+		template.Desc = nvme_metrics[intelNormalized]
+		template.Type = prometheus.GaugeValue
+		template.Tags = []string{d.name, name}
+		template.Value = float64(attr.Normalized)
+		out = append(out, template)
+
+		template.Tags = []string{d.name}
+		values := make(map[string]logValue)
+		switch attr.Id {
+		case 0xad:
+			values[intelWearLevelingMin] = logValue{prometheus.GaugeValue, float64(binary.LittleEndian.Uint16(attr.Raw[0:2]))}
+			values[intelWearLevelingMax] = logValue{prometheus.GaugeValue, float64(binary.LittleEndian.Uint16(attr.Raw[2:4]))}
+			values[intelWearLevelingAvg] = logValue{prometheus.GaugeValue, float64(binary.LittleEndian.Uint16(attr.Raw[4:6]))}
+		case 0xe2:
+			// in units of 1/1024 %
+			values[intelMediaWear] = logValue{prometheus.GaugeValue, float64(leUint(attr.Raw[:])) / 1024}
+		case 0xea:
+			values[intelThrottlePercent] = logValue{prometheus.GaugeValue, float64(attr.Raw[0])}
+			values[intelThrottleCount] = logValue{prometheus.CounterValue, float64(binary.LittleEndian.Uint32(attr.Raw[1:5]))}
+		case 0xf4:
+			// in units of 32MiB
+			values[intelNandBytesWritten] = logValue{prometheus.CounterValue, float64(leUint(attr.Raw[:])) * (32 << 20)}
+		case 0xf5:
+			values[intelHostBytesWritten] = logValue{prometheus.CounterValue, float64(leUint(attr.Raw[:])) * (32 << 20)}
+		default:
+			typ := prometheus.CounterValue
+			if _, ok := intel_gauge_attributes[attr.Id]; ok {
+				typ = prometheus.GaugeValue
+			}
+			values[metric_nvme_intel+name] = logValue{typ, float64(leUint(attr.Raw[:]))}
+		}
+		for metric_name, v := range values {
+			template.Desc = nvme_metrics[metric_name]
+			template.Type = v.Type
+			template.Value = v.Value
+			out = append(out, template)
+		}
+	}
+	return
+}
+
+type intelSmartAttr struct {
+	Id         uint8
+	Normalized uint8
+	Raw        [6]byte
+}
+
+// ParseIntelSmartLog splits the CAh log into its 12 byte attributes
+func ParseIntelSmartLog(buf []byte) (out []intelSmartAttr) {
+	for i := 0; i+nvme_intel_entry_size <= len(buf); i += nvme_intel_entry_size {
+		e := buf[i : i+nvme_intel_entry_size]
+		if e[0] == 0 {
+			break
+		}
+		attr := intelSmartAttr{Id: e[0], Normalized: e[3]}
+		copy(attr.Raw[:], e[5:11])
+		out = append(out, attr)
+	}
+	return
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/anatol/smart.go"
+)
+
+// smart.go only reads the SMART / Health log and Identify data, other admin
+// commands go through the passthrough below, see include/uapi/linux/nvme_ioctl.h
+
+const (
+	nvme_admin_get_log_page  = 0x02
+	nvme_admin_identify      = 0x06
+	nvme_admin_get_features  = 0x0a
+	nvme_admin_dev_self_test = 0x14
+
+	nvme_nsid_all = 0xffffffff
+
+	nvme_feature_power_management = 0x02
+
+	// max length of a single Get Log Page transfer, longer logs are read by offset
+	nvme_log_chunk = 4096
+)
+
+type nvmePassthruCmd64 struct {
+	opcode      uint8
+	flags       uint8
+	_           uint16
+	nsid        uint32
+	cdw2        uint32
+	cdw3        uint32
+	metadata    uint64
+	addr        uint64
+	metadataLen uint32
+	dataLen     uint32
+	cdw10       uint32
+	cdw11       uint32
+	cdw12       uint32
+	cdw13       uint32
+	cdw14       uint32
+	cdw15       uint32
+	timeoutMs   uint32
+	_           uint32
+	result      uint64
+}
+
+type nvmePassthruCmd struct {
+	opcode      uint8
+	flags       uint8
+	_           uint16
+	nsid        uint32
+	cdw2        uint32
+	cdw3        uint32
+	metadata    uint64
+	addr        uint64
+	metadataLen uint32
+	dataLen     uint32
+	cdw10       uint32
+	cdw11       uint32
+	cdw12       uint32
+	cdw13       uint32
+	cdw14       uint32
+	cdw15       uint32
+	timeoutMs   uint32
+	result      uint32
+}
+
+var (
+	nvme_ioctl_admin64_cmd = iowr('N', 0x47, unsafe.Sizeof(nvmePassthruCmd64{}))
+	nvme_ioctl_admin_cmd   = iowr('N', 0x41, unsafe.Sizeof(nvmePassthruCmd{}))
+)
+
+func iowr(t, nr, size uintptr) uintptr {
+	// direction read|write << 30 | size << 16 | type << 8 | nr
+	return 3<<30 | size<<16 | t<<8 | nr
+}
+
+// nvmeStatusError is the status field of a completion that did not succeed, as the
+// passthrough ioctl returns it: SC in bits 7:0, SCT in bits 10:8, then CRD, More and DNR
+type nvmeStatusError uint16
+
+const (
+	nvme_sct_generic          = 0x0
+	nvme_sct_command_specific = 0x1
+)
+
+func (e nvmeStatusError) SCT() uint8 {
+	return uint8(e >> 8 & 0x7)
+}
+
+func (e nvmeStatusError) SC() uint8 {
+	return uint8(e)
+}
+
+func (e nvmeStatusError) Error() string {
+	return fmt.Sprintf("nvme status: sct 0x%x sc 0x%02x", e.SCT(), e.SC())
+}
+
+// nvmeStatus turns the return value of the passthrough ioctl into an error
+func nvmeStatus(ret uintptr, err error) error {
+	if err == nil && ret != 0 {
+		return nvmeStatusError(ret)
+	}
+	return err
+}
+
+// nvmeAdmin sends an admin command and returns dword 0 of the completion. A
+// completion with an error status is returned as nvmeStatusError.
+func nvmeAdmin(fd int, cmd nvmePassthruCmd64, buf []byte) (uint32, error) {
+	if len(buf) != 0 {
+		cmd.addr = uint64(uintptr(unsafe.Pointer(&buf[0])))
+		cmd.dataLen = uint32(len(buf))
+	}
+	ret, err := ioctlRet(fd, nvme_ioctl_admin64_cmd, unsafe.Pointer(&cmd))
+	if err != syscall.ENOTTY {
+		return uint32(cmd.result), nvmeStatus(ret, err)
+	}
+
+	// fallback to legacy 32bit struct
+	legacy := nvmePassthruCmd{
+		opcode:  cmd.opcode,
+		nsid:    cmd.nsid,
+		addr:    cmd.addr,
+		dataLen: cmd.dataLen,
+		cdw10:   cmd.cdw10,
+		cdw11:   cmd.cdw11,
+		cdw12:   cmd.cdw12,
+		cdw13:   cmd.cdw13,
+		cdw14:   cmd.cdw14,
+		cdw15:   cmd.cdw15,
+	}
+	ret, err = ioctlRet(fd, nvme_ioctl_admin_cmd, unsafe.Pointer(&legacy))
+	return legacy.result, nvmeStatus(ret, err)
+}
+
+// nvmeGetLogPage fills buf with log page lid starting at offset, in chunks if buf is
+// longer than a single transfer
+func nvmeGetLogPage(fd int, nsid uint32, lid, lsp uint8, lsi uint16, offset uint64, buf []byte) error {
+	for start := 0; start < len(buf); start += nvme_log_chunk {
+		chunk := buf[start:min(start+nvme_log_chunk, len(buf))]
+		numd := uint32(len(chunk)/4 - 1)
+		off := offset + uint64(start)
+		_, err := nvmeAdmin(fd, nvmePassthruCmd64{
+			opcode: nvme_admin_get_log_page,
+			nsid:   nsid,
+			cdw10:  uint32(lid) | uint32(lsp&0x7f)<<8 | (numd&0xffff)<<16,
+			cdw11:  numd>>16 | uint32(lsi)<<16,
+			cdw12:  uint32(off),
+			cdw13:  uint32(off >> 32),
+		}, chunk)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nvmeIdentifyNamespace reads the Identify Namespace data structure of nsid
+func nvmeIdentifyNamespace(fd int, nsid uint32) (*smart.NvmeIdentNamespace, error) {
+	buf := make([]byte, 4096)
+	_, err := nvmeAdmin(fd, nvmePassthruCmd64{
+		opcode: nvme_admin_identify,
+		nsid:   nsid,
+		cdw10:  0, // CNS 00h
+	}, buf)
+	if err != nil {
+		return nil, err
+	}
+	var ns smart.NvmeIdentNamespace
+	if err = binary.Read(bytes.NewReader(buf), binary.LittleEndian, &ns); err != nil {
+		return nil, err
+	}
+	return &ns, nil
+}
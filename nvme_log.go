@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// decoders for the NVMe log pages smart.go does not read
+
+const (
+	nvme_log_error_info = 0x01
+
+	nvme_error_entry_size = 64
+)
+
+type nvmeErrorEntry struct {
+	ErrorCount uint64
+	SQID       uint16
+	CID        uint16
+	SCT        uint8 // status code type
+	SC         uint8 // status code
+	LBA        uint64
+	NSID       uint32
+}
+
+// parseNvmeErrorLog decodes the error information log (0x01). Entries with an
+// error count of 0 are unused and skipped.
+func parseNvmeErrorLog(buf []byte) (out []nvmeErrorEntry) {
+	for i := 0; i+nvme_error_entry_size <= len(buf); i += nvme_error_entry_size {
+		e := buf[i : i+nvme_error_entry_size]
+		count := binary.LittleEndian.Uint64(e[0:8])
+		if count == 0 {
+			continue
+		}
+		status := binary.LittleEndian.Uint16(e[12:14])
+		out = append(out, nvmeErrorEntry{
+			ErrorCount: count,
+			SQID:       binary.LittleEndian.Uint16(e[8:10]),
+			CID:        binary.LittleEndian.Uint16(e[10:12]),
+			SC:         uint8(status >> 1),
+			SCT:        uint8(status>>9) & 0x7,
+			LBA:        binary.LittleEndian.Uint64(e[16:24]),
+			NSID:       binary.LittleEndian.Uint32(e[24:28]),
+		})
+	}
+	return
+}
+
+const (
+	nvme_log_self_test = 0x06
+
+	nvme_self_test_log_size   = 564
+	nvme_self_test_entry_size = 28
+)
+
+// self-test codes, used both in the log and to start a test
+const (
+	nvme_self_test_short    = 0x1
+	nvme_self_test_extended = 0x2
+	nvme_self_test_vendor   = 0xe
+
+	nvme_oacs_self_test           = 1 << 4
+	nvme_sc_self_test_in_progress = 0x1d
+)
+
+var nvme_self_test_types = map[uint8]string{
+	nvme_self_test_short:    "short",
+	nvme_self_test_extended: "extended",
+	nvme_self_test_vendor:   "vendor",
+}
+
+type nvmeSelfTestResult struct {
+	Code        uint8
+	Result      uint8 // 0 passed, see NVMe spec for the rest
+	PowerOnHour uint64
+	FailingLBA  uint64
+}
+
+// Completed tells if the test ran to the end, whether it passed or found failed segments
+func (r nvmeSelfTestResult) Completed() bool {
+	return r.Result == 0 || r.Result == 6 || r.Result == 7
+}
+
+type nvmeSelfTestLog struct {
+	CurrentOperation  uint8 // 0 no test in progress
+	CurrentCompletion uint8 // percent
+	Results           []nvmeSelfTestResult
+}
+
+// parseNvmeSelfTestLog decodes the device self-test log (0x06), results are newest
+// first and unused entries are skipped
+func parseNvmeSelfTestLog(buf []byte) (l nvmeSelfTestLog) {
+	if len(buf) < 4 {
+		return
+	}
+	l.CurrentOperation = buf[0] & 0xf
+	l.CurrentCompletion = buf[1] & 0x7f
+	for i := 4; i+nvme_self_test_entry_size <= len(buf); i += nvme_self_test_entry_size {
+		e := buf[i : i+nvme_self_test_entry_size]
+		if e[0]&0xf == 0xf {
+			continue
+		}
+		l.Results = append(l.Results, nvmeSelfTestResult{
+			Code:        e[0] >> 4,
+			Result:      e[0] & 0xf,
+			PowerOnHour: binary.LittleEndian.Uint64(e[4:12]),
+			FailingLBA:  binary.LittleEndian.Uint64(e[16:24]),
+		})
+	}
+	return
+}
+
+const (
+	nvme_log_firmware_slot = 0x03
+
+	nvme_firmware_slot_log_size = 512
+)
+
+type nvmeFirmwareSlots struct {
+	Active    uint8     // slot running now
+	Pending   uint8     // slot activated at next reset, 0 if none
+	Revisions [7]string // revision in slot 1 to 7, empty if the slot is empty
+}
+
+// parseNvmeFirmwareSlots decodes the firmware slot information log (0x03)
+func parseNvmeFirmwareSlots(buf []byte) (f nvmeFirmwareSlots) {
+	if len(buf) < 64 {
+		return
+	}
+	f.Active = buf[0] & 0x7
+	f.Pending = buf[0] >> 4 & 0x7
+	for i := range f.Revisions {
+		f.Revisions[i] = string(bytes.TrimRight(buf[8+i*8:16+i*8], " \x00"))
+	}
+	return
+}
+
+// State tells if the 1-based slot is running now, activated at next reset, both or neither
+func (f nvmeFirmwareSlots) State(slot uint8) string {
+	switch {
+	case slot == f.Active && slot == f.Pending:
+		return "active_pending"
+	case slot == f.Active:
+		return "active"
+	case slot == f.Pending:
+		return "pending"
+	default:
+		return "inactive"
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Micron extended SMART log (E1h), as decoded by the micron plugin of nvme-cli
+
+const (
+	metric_nvme_micron = metric_nvme + "micron_"
+
+	nvme_log_micron_ext_smart      = 0xe1
+	nvme_micron_ext_smart_log_size = 512
+)
+
+type nvmeLogField struct {
+	name   string
+	offset int
+	size   int
+	typ    prometheus.ValueType
+}
+
+var nvme_micron_ext_smart_fields = []nvmeLogField{
+	{"grown_bad_blocks", 12, 4, prometheus.GaugeValue},
+	{"per_block_erase_count_max", 16, 4, prometheus.GaugeValue},
+	{"power_on_minutes", 20, 4, prometheus.CounterValue},
+	{"write_protect_reason", 48, 4, prometheus.GaugeValue},
+	{"drive_capacity", 64, 8, prometheus.GaugeValue},
+	{"total_erase_count", 80, 8, prometheus.CounterValue},
+	{"lifetime_use_rate", 88, 8, prometheus.GaugeValue},
+	{"erase_fail_count", 96, 8, prometheus.CounterValue},
+	{"reported_uncorrectable_errors", 112, 8, prometheus.CounterValue},
+	{"program_fail_count", 144, 8, prometheus.CounterValue},
+	{"total_bytes_read", 152, 16, prometheus.CounterValue},
+	{"total_bytes_written", 168, 16, prometheus.CounterValue},
+	{"total_block_stripes", 204, 4, prometheus.GaugeValue},
+	{"free_block_stripes", 208, 4, prometheus.GaugeValue},
+	{"block_stripe_size", 212, 8, prometheus.GaugeValue},
+	{"user_block_erase_count_min", 236, 4, prometheus.GaugeValue},
+	{"user_block_erase_count_avg", 240, 4, prometheus.GaugeValue},
+	{"user_block_erase_count_max", 244, 4, prometheus.GaugeValue},
+}
+
+type micronDecoder struct{}
+
+func (micronDecoder) ListMetrics() (out map[string]*prometheus.Desc) {
+	out = make(map[string]*prometheus.Desc)
+	for _, f := range nvme_micron_ext_smart_fields {
+		out[metric_nvme_micron+f.name] = prometheus.NewDesc(metric_nvme_micron+f.name, "", tags_dev_only, nil)
+	}
+	return
+}
+
+func (micronDecoder) GetMetrics(d *NvmeDev) (out []PromValue) {
+	if d.fd < 0 {
+		return
+	}
+	buf := make([]byte, nvme_micron_ext_smart_log_size)
+	if err := nvmeGetLogPage(d.fd, nvme_nsid_all, nvme_log_micron_ext_smart, 0, 0, 0, buf); err != nil {
+		slog.Debug("failed to read micron extended smart log", "dev", d.name, "err", err)
+		return
+	}
+	for name, v := range ParseMicronExtSmartLog(buf) {
+		out = append(out, PromValue{
+			Desc:  nvme_metrics[metric_nvme_micron+name],
+			Type:  v.Type,
+			Value: v.Value,
+			Tags:  []string{d.name},
+		})
+	}
+	return
+}
+
+// ParseMicronExtSmartLog decodes the fields of the E1h log
+func ParseMicronExtSmartLog(buf []byte) (out map[string]logValue) {
+	out = make(map[string]logValue)
+	if len(buf) < nvme_micron_ext_smart_log_size {
+		return
+	}
+	for _, f := range nvme_micron_ext_smart_fields {
+		out[f.name] = logValue{f.typ, leFloat(buf[f.offset : f.offset+f.size])}
+	}
+	return
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+
+	"github.com/anatol/smart.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OCP Datacenter NVMe SSD spec, SMART / Health Information Extended log (C0h)
+
+const (
+	metric_nvme_ocp = metric_nvme + "ocp_"
+
+	nvme_log_ocp_smart      = 0xc0
+	nvme_ocp_smart_log_size = 512
+)
+
+// log page GUID afd514c97c6f4f9ca4f2bfea2810afc5, stored little endian at offset 496
+var nvme_ocp_smart_guid = []byte{0xc5, 0xaf, 0x10, 0x28, 0xea, 0xbf, 0xf2, 0xa4, 0x9c, 0x4f, 0x6f, 0x7c, 0xc9, 0x14, 0xd5, 0xaf}
+
+type nvmeOcpField struct {
+	name        string
+	offset      int
+	size        int
+	typ         prometheus.ValueType
+	min_version uint16 // log page version the field was added in
+}
+
+var nvme_ocp_fields = []nvmeOcpField{
+	{"physical_media_units_written", 0, 16, prometheus.CounterValue, 0},
+	{"physical_media_units_read", 16, 16, prometheus.CounterValue, 0},
+	{"bad_user_nand_blocks_raw", 32, 6, prometheus.GaugeValue, 0},
+	{"bad_user_nand_blocks_normalized", 38, 2, prometheus.GaugeValue, 0},
+	{"bad_system_nand_blocks_raw", 40, 6, prometheus.GaugeValue, 0},
+	{"bad_system_nand_blocks_normalized", 46, 2, prometheus.GaugeValue, 0},
+	{"xor_recovery_count", 48, 8, prometheus.CounterValue, 0},
+	{"uncorrectable_read_errors", 56, 8, prometheus.CounterValue, 0},
+	{"soft_ecc_errors", 64, 8, prometheus.CounterValue, 0},
+	{"end_to_end_detected_errors", 72, 4, prometheus.CounterValue, 0},
+	{"end_to_end_corrected_errors", 76, 4, prometheus.CounterValue, 0},
+	{"system_data_percent_used", 80, 1, prometheus.GaugeValue, 0},
+	{"refresh_counts", 81, 7, prometheus.CounterValue, 0},
+	{"user_data_erase_count_max", 88, 4, prometheus.GaugeValue, 0},
+	{"user_data_erase_count_min", 92, 4, prometheus.GaugeValue, 0},
+	{"thermal_throttling_events", 96, 1, prometheus.CounterValue, 0},
+	{"thermal_throttling_status", 97, 1, prometheus.GaugeValue, 0},
+	{"pcie_correctable_errors", 104, 8, prometheus.CounterValue, 0},
+	{"incomplete_shutdowns", 112, 4, prometheus.CounterValue, 0},
+	{"percent_free_blocks", 120, 1, prometheus.GaugeValue, 0},
+	{"capacitor_health", 128, 2, prometheus.GaugeValue, 0},
+	{"unaligned_io", 136, 8, prometheus.CounterValue, 3},
+	{"security_version_number", 144, 8, prometheus.GaugeValue, 3},
+	{"total_nuse", 152, 8, prometheus.GaugeValue, 3},
+	{"plp_start_count", 160, 16, prometheus.CounterValue, 3},
+	{"endurance_estimate", 176, 16, prometheus.GaugeValue, 3},
+	{"pcie_link_retraining_count", 192, 8, prometheus.CounterValue, 3},
+	{"power_state_change_count", 200, 8, prometheus.CounterValue, 3},
+}
+
+func list_nvme_ocp_metrics() (out map[string]*prometheus.Desc) {
+	out = make(map[string]*prometheus.Desc)
+	for _, f := range nvme_ocp_fields {
+		out[metric_nvme_ocp+f.name] = prometheus.NewDesc(metric_nvme_ocp+f.name, "", tags_dev_only, nil)
+	}
+	return
+}
+
+// readOcpSmartLog reads the C0h log, the page is vendor specific so it is only
+// trusted if it carries the OCP GUID
+func (d *NvmeDev) readOcpSmartLog() ([]byte, bool) {
+	if d.fd < 0 {
+		return nil, false
+	}
+	buf := make([]byte, nvme_ocp_smart_log_size)
+	if err := nvmeGetLogPage(d.fd, nvme_nsid_all, nvme_log_ocp_smart, 0, 0, 0, buf); err != nil {
+		return nil, false
+	}
+	return buf, bytes.Equal(buf[496:512], nvme_ocp_smart_guid)
+}
+
+func (d *NvmeDev) ocpMetrics() (out []PromValue) {
+	if !d.ocp {
+		return
+	}
+	buf, ok := d.readOcpSmartLog()
+	if !ok {
+		slog.Debug("failed to read ocp smart log", "dev", d.name)
+		return
+	}
+	for name, v := range ParseOcpSmartLog(buf) {
+		out = append(out, PromValue{
+			Desc:  nvme_metrics[metric_nvme_ocp+name],
+			Type:  v.Type,
+			Value: v.Value,
+			Tags:  []string{d.name},
+		})
+	}
+	return
+}
+
+type logValue struct {
+	Type  prometheus.ValueType
+	Value float64
+}
+
+// ParseOcpSmartLog decodes the fields of the C0h log supported by its version
+func ParseOcpSmartLog(buf []byte) (out map[string]logValue) {
+	out = make(map[string]logValue)
+	if len(buf) < nvme_ocp_smart_log_size {
+		return
+	}
+	version := binary.LittleEndian.Uint16(buf[494:496])
+	for _, f := range nvme_ocp_fields {
+		if version < f.min_version {
+			continue
+		}
+		field := buf[f.offset : f.offset+f.size]
+		out[f.name] = logValue{f.typ, leFloat(field)}
+	}
+	return
+}
+
+// leFloat decodes a little endian field of up to 16 bytes
+func leFloat(b []byte) float64 {
+	if len(b) == 16 {
+		return Uint128toFloat64(smart.Uint128{Val: [2]uint64{binary.LittleEndian.Uint64(b[:8]), binary.LittleEndian.Uint64(b[8:])}})
+	}
+	return float64(leUint(b))
+}
+
+func leUint(b []byte) (v uint64) {
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return
+}
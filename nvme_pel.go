@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Persistent Event log (0Dh) and Telemetry Host-Initiated log (07h) header, NVMe 1.4
+
+const (
+	nvme_log_telemetry_host = 0x07
+	nvme_log_persistent     = 0x0d
+
+	// log specific field of the persistent event log
+	nvme_pel_read      = 0
+	nvme_pel_establish = 1
+	nvme_pel_release   = 2
+
+	nvme_pel_header_size = 512
+	nvme_pel_event_head  = 24
+	// longest log walked from the start, on the first read or after older events were deleted
+	nvme_pel_max_size = 4 << 20
+
+	nvme_telemetry_header_size = 512
+
+	// identify controller LPA bits
+	nvme_lpa_telemetry  = 1 << 3
+	nvme_lpa_persistent = 1 << 4
+
+	nvmePersistentEvents        = metric_nvme + "persistent_events_total"
+	nvmePersistentEventLastTime = metric_nvme + "persistent_event_last_timestamp_seconds"
+	nvmeTelemetryHostGeneration = metric_nvme + "telemetry_host_generation"
+	nvmeTelemetryCtrlAvailable  = metric_nvme + "telemetry_controller_available"
+	nvmeTelemetryCtrlGeneration = metric_nvme + "telemetry_controller_generation"
+)
+
+var nvme_persistent_event_types = map[uint8]string{
+	0x01: "smart_health_snapshot",
+	0x02: "firmware_commit",
+	0x03: "timestamp_change",
+	0x04: "power_on_reset",
+	0x05: "hardware_error",
+	0x06: "change_namespace",
+	0x07: "format_start",
+	0x08: "format_completion",
+	0x09: "sanitize_start",
+	0x0a: "sanitize_completion",
+	0x0b: "set_feature",
+	0x0c: "telemetry_log_created",
+	0x0d: "thermal_excursion",
+	0xde: "vendor_specific",
+	0xdf: "tcg_defined",
+}
+
+func nvmePersistentEventType(t uint8) string {
+	if name, ok := nvme_persistent_event_types[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", t)
+}
+
+func list_nvme_pel_metrics() (out map[string]*prometheus.Desc) {
+	out = make(map[string]*prometheus.Desc)
+	out[nvmePersistentEvents] = prometheus.NewDesc(nvmePersistentEvents, "", tags_dev_type, nil)
+	out[nvmePersistentEventLastTime] = prometheus.NewDesc(nvmePersistentEventLastTime, "", tags_dev_type, nil)
+	for _, metric_name := range []string{nvmeTelemetryHostGeneration, nvmeTelemetryCtrlAvailable, nvmeTelemetryCtrlGeneration} {
+		out[metric_name] = prometheus.NewDesc(metric_name, "", tags_dev_only, nil)
+	}
+	return
+}
+
+type nvmePersistentEvent struct {
+	Type      uint8
+	Timestamp uint64 // milliseconds since the epoch, 0 if the controller had no timestamp set
+	Data      []byte // the whole event, header included
+}
+
+// ParseNvmePersistentEvents walks up to total events at the start of buf, which
+// begins at an event boundary of the persistent event log, e.g. right after its 512
+// byte header. Events are in the order they were recorded, oldest first.
+func ParseNvmePersistentEvents(buf []byte, total int) (out []nvmePersistentEvent) {
+	off := 0
+	for range total {
+		if off+nvme_pel_event_head > len(buf) {
+			break
+		}
+		head := buf[off:]
+		size := int(head[2]) + 3 + int(binary.LittleEndian.Uint16(head[22:24]))
+		if off+size > len(buf) {
+			break
+		}
+		out = append(out, nvmePersistentEvent{
+			Type:      head[0],
+			Timestamp: binary.LittleEndian.Uint64(head[6:14]) & (1<<48 - 1),
+			Data:      buf[off : off+size],
+		})
+		off += size
+	}
+	return
+}
+
+// readPersistentEvents reads bytes start to end of the log in the current reporting
+// context, the transfer is widened to dword boundaries
+func (d *NvmeDev) readPersistentEvents(start, end uint64) ([]byte, error) {
+	aligned := start &^ 3
+	buf := make([]byte, (end+3)&^3-aligned)
+	if err := nvmeGetLogPage(d.fd, nvme_nsid_all, nvme_log_persistent, nvme_pel_read, 0, aligned, buf); err != nil {
+		return nil, err
+	}
+	return buf[start-aligned : end-aligned], nil
+}
+
+// newPersistentEvents returns the events recorded after the last counted one, reading
+// the log with read. While the log only grows just the bytes after that event are read,
+// while its length stays the same the event is checked to still end the log. When the
+// controller deleted older events to make room, the whole log is read and the last
+// counted event is looked up by its content. A new generation number means the log
+// was cleared, so every event in it is new.
+func (d *NvmeDev) newPersistentEvents(header []byte, read func(start, end uint64) ([]byte, error)) ([]nvmePersistentEvent, error) {
+	total := int(binary.LittleEndian.Uint32(header[4:8]))
+	length := binary.LittleEndian.Uint64(header[8:16])
+	generation := binary.LittleEndian.Uint16(header[372:374])
+	continued := d.pel_read && generation == d.pel_generation
+	tail := uint64(len(d.pel_tail))
+	switch {
+	case continued && tail == 0 && length == d.pel_length:
+		// nothing was counted to compare with
+		return nil, nil
+	case continued && tail != 0 && length >= d.pel_length && d.pel_length >= nvme_pel_header_size+tail:
+		start := d.pel_length - tail
+		if length == d.pel_length {
+			start = length - tail
+		}
+		buf, err := read(start, length)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(buf, d.pel_tail) {
+			return ParseNvmePersistentEvents(buf[tail:], total), nil
+		}
+	}
+
+	if length > nvme_pel_max_size {
+		// too long to walk on every change, only events recorded from now on are counted
+		slog.Debug("persistent event log too long, skipping recorded events", "dev", d.name, "length", length)
+		d.pel_tail = nil
+		return nil, nil
+	}
+	var events []nvmePersistentEvent
+	if length > nvme_pel_header_size {
+		buf, err := read(nvme_pel_header_size, length)
+		if err != nil {
+			return nil, err
+		}
+		events = ParseNvmePersistentEvents(buf, total)
+	}
+	if continued && tail != 0 {
+		for i := len(events) - 1; i >= 0; i-- {
+			if bytes.Equal(events[i].Data, d.pel_tail) {
+				return events[i+1:], nil
+			}
+		}
+	}
+	return events, nil
+}
+
+// countPersistentEvents adds the new events of the log described by header to the
+// counters and moves the position after them, mu must be held
+func (d *NvmeDev) countPersistentEvents(header []byte, read func(start, end uint64) ([]byte, error)) error {
+	events, err := d.newPersistentEvents(header, read)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		d.pel_events[e.Type]++
+		if e.Timestamp != 0 {
+			d.pel_last[e.Type] = e.Timestamp
+		}
+	}
+	if len(events) != 0 {
+		d.pel_tail = bytes.Clone(events[len(events)-1].Data)
+	}
+	d.pel_generation = binary.LittleEndian.Uint16(header[372:374])
+	d.pel_length = binary.LittleEndian.Uint64(header[8:16])
+	d.pel_read = true
+	return nil
+}
+
+// persistentEventMetrics counts the events recorded since the previous scrape. The
+// reporting context is held only while mu is, so concurrent scrapes do not interfere,
+// and it is released again for other tools.
+func (d *NvmeDev) persistentEventMetrics() (out []PromValue) {
+	if d.fd < 0 || d.lpa&nvme_lpa_persistent == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	header := make([]byte, nvme_pel_header_size)
+	if err := nvmeGetLogPage(d.fd, nvme_nsid_all, nvme_log_persistent, nvme_pel_establish, 0, 0, header); err != nil {
+		slog.Debug("failed to establish persistent event log context", "dev", d.name, "err", err)
+		return
+	}
+	err := d.countPersistentEvents(header, d.readPersistentEvents)
+	if err := nvmeGetLogPage(d.fd, nvme_nsid_all, nvme_log_persistent, nvme_pel_release, 0, 0, make([]byte, nvme_pel_header_size)); err != nil {
+		slog.Debug("failed to release persistent event log context", "dev", d.name, "err", err)
+	}
+	if err != nil {
+		slog.Debug("failed to read persistent event log", "dev", d.name, "err", err)
+		return
+	}
+
+	for t, count := range d.pel_events {
+		tags := []string{d.name, nvmePersistentEventType(t)}
+		out = append(out, PromValue{
+			Desc:  nvme_metrics[nvmePersistentEvents],
+			Type:  prometheus.CounterValue,
+			Value: float64(count),
+			Tags:  tags,
+		})
+		if d.pel_last[t] == 0 {
+			continue
+		}
+		out = append(out, PromValue{
+			Desc:  nvme_metrics[nvmePersistentEventLastTime],
+			Type:  prometheus.GaugeValue,
+			Value: float64(d.pel_last[t]) / 1000,
+			Tags:  tags,
+		})
+	}
+	return
+}
+
+// telemetryMetrics reports the header of the Telemetry Host-Initiated log without
+// creating new telemetry data, so the generation numbers show when the controller
+// or a previous host request captured a new snapshot
+func (d *NvmeDev) telemetryMetrics() (out []PromValue) {
+	if d.fd < 0 || d.lpa&nvme_lpa_telemetry == 0 {
+		return
+	}
+	buf := make([]byte, nvme_telemetry_header_size)
+	if err := nvmeGetLogPage(d.fd, nvme_nsid_all, nvme_log_telemetry_host, 0, 0, 0, buf); err != nil {
+		slog.Debug("failed to read telemetry log", "dev", d.name, "err", err)
+		return
+	}
+	template := PromValue{
+		Type: prometheus.GaugeValue,
+		Tags: []string{d.name},
+	}
+	values := map[string]uint8{
+		nvmeTelemetryHostGeneration: buf[381],
+		nvmeTelemetryCtrlAvailable:  buf[382],
+		nvmeTelemetryCtrlGeneration: buf[383],
+	}
+	for name, value := range values {
+		template.Desc = nvme_metrics[name]
+		template.Value = float64(value)
+		out = append(out, template)
+	}
+	return
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// nvmeVendorDecoder contributes metrics from vendor specific log pages of a controller
+type nvmeVendorDecoder interface {
+	// ListMetrics returns all metrics the decoder may export
+	ListMetrics() map[string]*prometheus.Desc
+	// GetMetrics reads the vendor log pages of d, it is only called on controllers
+	// with a PCI vendor ID the decoder is registered for
+	GetMetrics(d *NvmeDev) []PromValue
+}
+
+// nvme_vendor_decoders is keyed by PCI vendor ID from Identify Controller
+var nvme_vendor_decoders = map[uint16]nvmeVendorDecoder{
+	0x8086: intelDecoder{},  // Intel
+	0x025e: intelDecoder{},  // Solidigm, uses the same logs as Intel
+	0x1344: micronDecoder{}, // Micron
+	0x1b96: wdcDecoder{},    // Western Digital
+	0x1c58: wdcDecoder{},    // HGST, now Western Digital
+}
+
+func list_nvme_vendor_metrics() (out map[string]*prometheus.Desc) {
+	out = make(map[string]*prometheus.Desc)
+	for _, dec := range nvme_vendor_decoders {
+		for name, desc := range dec.ListMetrics() {
+			out[name] = desc
+		}
+	}
+	return
+}
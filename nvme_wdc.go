@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/binary"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WDC additional SMART log (C1h), as decoded by the wdc plugin of nvme-cli. The log is
+// a list of subpages, the lifetime performance statistics are read from it.
+
+const (
+	metric_nvme_wdc = metric_nvme + "wdc_"
+
+	nvme_log_wdc_add      = 0xc1
+	nvme_wdc_add_log_size = 16 << 10
+
+	wdc_subpage_perf     = 0x37
+	wdc_perf_lifetime    = 0x0f
+	wdc_subpage_head     = 4
+	wdc_log_header_size  = 4
+	wdc_perf_stats_count = 15
+)
+
+// counters of the performance statistics subpage, in order
+var wdc_perf_stats = [wdc_perf_stats_count]string{
+	"host_read_commands",
+	"host_read_blocks",
+	"host_read_cache_hit_commands",
+	"host_read_cache_hit_blocks",
+	"host_read_stalled_commands",
+	"host_write_commands",
+	"host_write_blocks",
+	"host_write_odd_start_commands",
+	"host_write_odd_end_commands",
+	"host_write_stalled_commands",
+	"nand_read_commands",
+	"nand_read_blocks",
+	"nand_write_commands",
+	"nand_write_blocks",
+	"nand_read_before_write",
+}
+
+type wdcDecoder struct{}
+
+func (wdcDecoder) ListMetrics() (out map[string]*prometheus.Desc) {
+	out = make(map[string]*prometheus.Desc)
+	for _, name := range wdc_perf_stats {
+		out[metric_nvme_wdc+name] = prometheus.NewDesc(metric_nvme_wdc+name, "", tags_dev_only, nil)
+	}
+	return
+}
+
+func (wdcDecoder) GetMetrics(d *NvmeDev) (out []PromValue) {
+	if d.fd < 0 {
+		return
+	}
+	buf := make([]byte, nvme_wdc_add_log_size)
+	if err := nvmeGetLogPage(d.fd, nvme_nsid_all, nvme_log_wdc_add, 0, 0, 0, buf); err != nil {
+		slog.Debug("failed to read wdc additional smart log", "dev", d.name, "err", err)
+		return
+	}
+	for name, value := range ParseWdcPerfStats(buf) {
+		out = append(out, PromValue{
+			Desc:  nvme_metrics[metric_nvme_wdc+name],
+			Type:  prometheus.CounterValue,
+			Value: float64(value),
+			Tags:  []string{d.name},
+		})
+	}
+	return
+}
+
+// ParseWdcPerfStats walks the subpages of the C1h log for the lifetime performance
+// statistics, it returns nothing if the log has none
+func ParseWdcPerfStats(buf []byte) (out map[string]uint64) {
+	out = make(map[string]uint64)
+	for p := wdc_log_header_size; p+wdc_subpage_head <= len(buf); {
+		code, set := buf[p], buf[p+1]
+		length := int(binary.LittleEndian.Uint16(buf[p+2 : p+4]))
+		if code == wdc_subpage_perf && set == wdc_perf_lifetime {
+			stats := buf[p+wdc_subpage_head:]
+			if len(stats) < wdc_perf_stats_count*8 {
+				return
+			}
+			for i, name := range wdc_perf_stats {
+				out[name] = binary.LittleEndian.Uint64(stats[i*8:])
+			}
+			return
+		}
+		if length == 0 {
+			return
+		}
+		p += wdc_subpage_head + length
+	}
+	return
+}
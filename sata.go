@@ -1,28 +1,59 @@
 package main
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"maps"
 	"math/bits"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/anatol/smart.go"
 	"github.com/dustin/go-humanize"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
 )
 
 const metric_sata = metric_head + "sata_"
 
 type SataDev struct {
-	name     string
-	dev      *smart.SataDevice
-	dev_info []string
+	name       string
+	dev        *smart.SataDevice
+	fd         int
+	dev_info   []string
+	thresholds map[uint8]uint8
+	// pages of the device statistics log with known statistics
+	devstat_pages []uint8
+	sct           bool // SCT status is readable
+	// attribute names and raw formats from the drive database, nil if none is loaded
+	presets map[uint8]driveDbAttr
+	// names and raw value types smart.go gives the attributes, to decode the SMART
+	// data read here the same way. nil if it could not be read when opening.
+	attr_mapping map[uint8]sataAttrMapping
+
+	// error log entries seen in previous scrapes, guarded by mu
+	mu               sync.Mutex
+	last_error_count uint64
+	error_types      map[string]uint64
+	smart_page       []byte // SMART data of the last scrape, nil if not read by fd
+}
+
+type sataAttrMapping struct {
+	name string
+	typ  int
 }
 
 func NewSataDev(name string, smartdev *smart.SataDevice) (d *SataDev) {
-	d = &SataDev{name, smartdev, nil}
+	d = &SataDev{name: name, dev: smartdev, error_types: make(map[string]uint64)}
+	var err error
+	d.fd, err = openRaw(devPath(name))
+	if err != nil {
+		slog.Warn("failed to open sata dev, ata logs are not available", "dev", name, "err", err)
+		d.fd = -1
+	}
 	id, err := d.dev.Identify()
 	if err == nil {
 		sectors, capacity, logicalSectorSize, physicalSectorSize, _ := id.Capacity()
@@ -40,10 +71,32 @@ func NewSataDev(name string, smartdev *smart.SataDevice) (d *SataDev) {
 			fmt.Sprintf("%d rpm", id.RotationRate),
 			ParseSATAVersion(id),
 		}
+		if drive_db != nil {
+			var family string
+			family, d.presets = drive_db.Lookup(id.ModelNumber(), id.FirmwareRevision(), id.RotationRate == 1)
+			slog.Debug("matched drive database", "dev", name, "family", family)
+		}
 	} else {
 		d.dev_info = make([]string, len(tags_sata_info))
 		d.dev_info[0] = name
 	}
+	thresholds, err := d.dev.ReadSMARTThresholds()
+	if err == nil {
+		d.thresholds = thresholds.Thresholds
+	} else {
+		slog.Warn("failed to read smart thresholds", "dev", name, "err", err)
+	}
+	if data, err := d.dev.ReadSMARTData(); err == nil {
+		d.attr_mapping = make(map[uint8]sataAttrMapping)
+		for num, attr := range data.Attrs {
+			d.attr_mapping[num] = sataAttrMapping{attr.Name, attr.Type}
+		}
+	}
+	if d.fd >= 0 {
+		d.devstat_pages = d.devstatPages()
+		_, err = d.readSctStatus()
+		d.sct = err == nil
+	}
 	return
 }
 
@@ -61,11 +114,40 @@ func getMetricName(attrName string, num uint8) (metricName string) {
 	}
 }
 
+// attrName returns the metric name of attr, named by the drive database if it has one
+func (d *SataDev) attrName(num uint8, attr smart.AtaSmartAttr) string {
+	if preset, ok := d.presets[num]; ok && len(preset.name) != 0 {
+		return getMetricName(preset.name, num)
+	}
+	return getMetricName(attr.Name, num)
+}
+
 func toHex(num uint8) string {
 	return strings.ToUpper(hex.EncodeToString([]byte{num}))
 }
 
-const sata_info_metric = metric_sata + "Info"
+const (
+	sata_info_metric    = metric_sata + "Info"
+	sataAttributeRaw    = metric_sata + "attribute_raw"
+	sataAttributeValue  = metric_sata + "attribute_value"
+	sataAttributeWorst  = metric_sata + "attribute_worst"
+	sataAttributeThresh = metric_sata + "attribute_threshold"
+	sataAttributeFlags  = metric_sata + "attribute_flags"
+	sataAttributeFailed = metric_sata + "attribute_failed"
+)
+
+// bits of the ATA SMART attribute flags word
+var sata_attribute_flags = []struct {
+	mask uint16
+	name string
+}{
+	{0x0001, "prefailure"},
+	{0x0002, "online"},
+	{0x0004, "performance"},
+	{0x0008, "error_rate"},
+	{0x0010, "event_count"},
+	{0x0020, "self_preserving"},
+}
 
 var (
 	tags_sata_info = []string{
@@ -81,11 +163,30 @@ var (
 		// I do not know how smartctl read "Form Factor"
 		"SATA_Version",
 	}
-	sata_metrics = map[string]*prometheus.Desc{
-		sata_info_metric: prometheus.NewDesc(sata_info_metric, "", tags_sata_info, nil),
-	}
+	tags_sata_attribute        = []string{tag_dev, "id", "attribute"}
+	tags_sata_attribute_flag   = []string{tag_dev, "id", "attribute", "flag"}
+	tags_sata_attribute_failed = []string{tag_dev, "id", "attribute", "type", "when"}
+	sata_metrics               = list_sata_metrics()
 )
 
+func list_sata_metrics() (out map[string]*prometheus.Desc) {
+	out = map[string]*prometheus.Desc{
+		sata_info_metric:    prometheus.NewDesc(sata_info_metric, "", tags_sata_info, nil),
+		sataAttributeRaw:    prometheus.NewDesc(sataAttributeRaw, "", tags_sata_attribute, nil),
+		sataAttributeValue:  prometheus.NewDesc(sataAttributeValue, "", tags_sata_attribute, nil),
+		sataAttributeWorst:  prometheus.NewDesc(sataAttributeWorst, "", tags_sata_attribute, nil),
+		sataAttributeThresh: prometheus.NewDesc(sataAttributeThresh, "", tags_sata_attribute, nil),
+		sataAttributeFlags:  prometheus.NewDesc(sataAttributeFlags, "", tags_sata_attribute_flag, nil),
+		sataAttributeFailed: prometheus.NewDesc(sataAttributeFailed, "", tags_sata_attribute_failed, nil),
+	}
+	maps.Copy(out, list_sata_devstat_metrics())
+	maps.Copy(out, list_sata_error_log_metrics())
+	maps.Copy(out, list_sata_self_test_metrics())
+	maps.Copy(out, list_sata_sct_metrics())
+	maps.Copy(out, list_sata_phy_metrics())
+	return
+}
+
 func (d *SataDev) ListMetrics() map[string]*prometheus.Desc {
 	data, err := d.dev.ReadSMARTData()
 	if err != nil {
@@ -94,7 +195,7 @@ func (d *SataDev) ListMetrics() map[string]*prometheus.Desc {
 	var name string
 	var ok bool
 	for num, attr := range data.Attrs {
-		name = getMetricName(attr.Name, num)
+		name = d.attrName(num, attr)
 		if _, ok = sata_metrics[name]; ok {
 			continue
 		}
@@ -104,7 +205,10 @@ func (d *SataDev) ListMetrics() map[string]*prometheus.Desc {
 }
 
 func (d *SataDev) GetMetrics() (out []PromValue) {
-	data, err := d.dev.ReadSMARTData()
+	attrs, reserved, page, err := d.readSmartData()
+	d.mu.Lock()
+	d.smart_page = page
+	d.mu.Unlock()
 	if err != nil {
 		return
 	}
@@ -115,16 +219,21 @@ func (d *SataDev) GetMetrics() (out []PromValue) {
 		Tags: []string{d.name},
 	}
 
-	for num, attr := range data.Attrs {
-		name = getMetricName(attr.Name, num)
+	for num, attr := range attrs {
+		if num == 231 { // disabled attr
+			continue
+		}
+		name = d.attrName(num, attr)
+		out = append(out, d.attributeMetrics(num, attr, strings.TrimPrefix(name, metric_sata))...)
 		if template.Desc, ok = sata_metrics[name]; !ok {
 			slog.Warn("failed to find metric, didn't run ListMetrics?", "name", name)
 			continue
 		}
-		switch num {
-		case 231: // disabled attr
-			continue
-		case 194:
+		preset, has_preset := d.presets[num]
+		switch {
+		case has_preset:
+			template.Value = preset.Value(attr, reserved[num])
+		case num == 194:
 			var temp int
 			temp, _, _, _, err = attr.ParseAsTemperature()
 			if err != nil {
@@ -132,7 +241,7 @@ func (d *SataDev) GetMetrics() (out []PromValue) {
 				continue
 			}
 			template.Value = float64(temp)
-		case 03:
+		case num == 03:
 			attr.ValueRaw, _ = ParseSpinUpTime(attr.ValueRaw)
 			fallthrough
 		default:
@@ -141,6 +250,12 @@ func (d *SataDev) GetMetrics() (out []PromValue) {
 		out = append(out, template)
 	}
 
+	out = append(out, d.devstatMetrics()...)
+	out = append(out, d.errorLogMetrics()...)
+	out = append(out, d.selfTestMetrics(page)...)
+	out = append(out, d.sctMetrics()...)
+	out = append(out, d.phyEventMetrics()...)
+
 	template.Desc, ok = sata_metrics[sata_info_metric]
 	if ok {
 		template.Tags = d.dev_info
@@ -151,7 +266,178 @@ func (d *SataDev) GetMetrics() (out []PromValue) {
 	return
 }
 
+// readSmartData sends SMART READ DATA once per scrape, the page is returned for the
+// parsers of its other fields. It falls back to smart.go, without the page, if the dev
+// is not opened here.
+func (d *SataDev) readSmartData() (attrs map[uint8]smart.AtaSmartAttr, reserved map[uint8]uint8, page []byte, err error) {
+	if d.fd < 0 || d.attr_mapping == nil {
+		var data *smart.AtaSmartPage
+		if data, err = d.dev.ReadSMARTData(); err != nil {
+			return
+		}
+		return data.Attrs, nil, nil, nil
+	}
+	page = make([]byte, ata_log_page)
+	if err = ataSmartRead(d.fd, smart_read_data, 0, page); err != nil {
+		return nil, nil, nil, err
+	}
+	attrs, reserved = ParseAtaSmartData(page, d.attr_mapping)
+	return
+}
+
+// ParseAtaSmartData decodes the attribute table of SMART READ DATA like smart.go
+// does, 30 entries of 12 bytes from offset 2. The reserved byte, which smart.go
+// drops, is returned separately.
+func ParseAtaSmartData(page []byte, mapping map[uint8]sataAttrMapping) (attrs map[uint8]smart.AtaSmartAttr, reserved map[uint8]uint8) {
+	attrs = make(map[uint8]smart.AtaSmartAttr)
+	reserved = make(map[uint8]uint8)
+	for i := 2; i+12 <= min(len(page), 2+30*12); i += 12 {
+		e := page[i : i+12]
+		if e[0] == 0 {
+			break
+		}
+		attr := smart.AtaSmartAttr{
+			Id:      e[0],
+			Flags:   binary.LittleEndian.Uint16(e[1:3]),
+			Current: e[3],
+			Worst:   e[4],
+		}
+		copy(attr.VendorBytes[:], e[5:11])
+		reserved[attr.Id] = e[11]
+		if m, ok := mapping[attr.Id]; ok {
+			attr.Name, attr.Type = m.name, m.typ
+			attr.ValueRaw = driveDbAttr{byte_order: sataRawOrder(m.typ)}.rawValue(attr, e[11])
+		}
+		attrs[attr.Id] = attr
+	}
+	return
+}
+
+// sataRawOrder is the byte order smart.go assembles raw values of typ in
+func sataRawOrder(typ int) string {
+	switch typ {
+	case smart.AtaDeviceAttributeTypeRaw64, smart.AtaDeviceAttributeTypeHex64:
+		return "543210wv"
+	case smart.AtaDeviceAttributeTypeRaw56, smart.AtaDeviceAttributeTypeHex56,
+		smart.AtaDeviceAttributeTypeRaw24DivRaw32, smart.AtaDeviceAttributeTypeMsec24Hour32:
+		return "r543210"
+	default:
+		return "543210"
+	}
+}
+
+// attributeMetrics returns raw, normalized, worst and threshold values of attr with
+// the attribute id as a label, so that they can be compared across vendors
+func (d *SataDev) attributeMetrics(num uint8, attr smart.AtaSmartAttr, name string) (out []PromValue) {
+	template := PromValue{
+		Type: prometheus.GaugeValue,
+		Tags: []string{d.name, strconv.Itoa(int(num)), name},
+	}
+	values := map[string]float64{
+		sataAttributeRaw:   float64(attr.ValueRaw),
+		sataAttributeValue: float64(attr.Current),
+		sataAttributeWorst: float64(attr.Worst),
+	}
+	thresh, has_thresh := d.thresholds[num]
+	if has_thresh {
+		values[sataAttributeThresh] = float64(thresh)
+	}
+	for metric_name, value := range values {
+		template.Desc = sata_metrics[metric_name]
+		template.Value = value
+		out = append(out, template)
+	}
+
+	template.Desc = sata_metrics[sataAttributeFlags]
+	for _, flag := range sata_attribute_flags {
+		template.Tags = []string{d.name, strconv.Itoa(int(num)), name, flag.name}
+		template.Value = 0
+		if attr.Flags&flag.mask != 0 {
+			template.Value = 1
+		}
+		out = append(out, template)
+	}
+
+	if !has_thresh {
+		return
+	}
+	typ := "old_age"
+	if attr.Flags&smart.AtaAttributeFlagPrefailure != 0 {
+		typ = "prefail"
+	}
+	now, past := AttributeFailed(attr.Current, attr.Worst, thresh)
+	template.Desc = sata_metrics[sataAttributeFailed]
+	for when, failed := range map[string]bool{"now": now, "past": past} {
+		template.Tags = []string{d.name, strconv.Itoa(int(num)), name, typ, when}
+		template.Value = 0
+		if failed {
+			template.Value = 1
+		}
+		out = append(out, template)
+	}
+	return
+}
+
+// AttributeFailed works out the WHEN_FAILED column of smartctl. A threshold of 0 means
+// the attribute never fails, failing now takes precedence over failed in the past.
+func AttributeFailed(current, worst, thresh uint8) (now bool, past bool) {
+	if thresh == 0 {
+		return
+	}
+	if current <= thresh {
+		return true, false
+	}
+	return false, worst <= thresh
+}
+
+func (d *SataDev) Type() string {
+	return d.dev.Type()
+}
+
+// Health asks the drive with SMART RETURN STATUS, if the SATL does not pass the
+// result back it falls back to look for pre-fail attributes failing now
+func (d *SataDev) Health() (ok bool, reason string, err error) {
+	if d.fd >= 0 {
+		var passed bool
+		passed, err = ataSmartReturnStatus(d.fd)
+		if err == nil {
+			if passed {
+				return true, "SMART RETURN STATUS PASSED", nil
+			}
+			return false, "SMART RETURN STATUS FAILED", nil
+		}
+		slog.Debug("failed to get smart return status", "dev", d.name, "err", err)
+	}
+	// the SMART data of the scrape this is part of, if there is one
+	d.mu.Lock()
+	page := d.smart_page
+	d.mu.Unlock()
+	var attrs map[uint8]smart.AtaSmartAttr
+	if page != nil {
+		attrs, _ = ParseAtaSmartData(page, d.attr_mapping)
+	} else {
+		data, err := d.dev.ReadSMARTData()
+		if err != nil {
+			return false, "", err
+		}
+		attrs = data.Attrs
+	}
+	for num, attr := range attrs {
+		thresh, has_thresh := d.thresholds[num]
+		if !has_thresh || attr.Flags&smart.AtaAttributeFlagPrefailure == 0 {
+			continue
+		}
+		if now, _ := AttributeFailed(attr.Current, attr.Worst, thresh); now {
+			return false, "FAILING_NOW " + strings.TrimPrefix(d.attrName(num, attr), metric_sata), nil
+		}
+	}
+	return true, "no pre-fail attribute failing now", nil
+}
+
 func (d *SataDev) Close() error {
+	if d.fd >= 0 {
+		unix.Close(d.fd)
+	}
 	return d.dev.Close()
 }
 
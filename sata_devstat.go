@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Device Statistics log (GP log 04h), ACS-4 9.5
+
+const (
+	metric_sata_devstat = metric_sata + "devstat_"
+
+	ata_log_device_statistics = 0x04
+
+	// bits of a statistic qword
+	devstat_supported  = 1 << 63
+	devstat_valid      = 1 << 62
+	devstat_value_mask = 1<<48 - 1
+)
+
+type ataDevstatField struct {
+	name   string
+	page   uint8
+	offset int
+	signed bool // temperatures are signed bytes
+	typ    prometheus.ValueType
+}
+
+var ata_devstat_fields = []ataDevstatField{
+	// general statistics
+	{"power_on_resets", 0x01, 8, false, prometheus.CounterValue},
+	{"power_on_hours", 0x01, 16, false, prometheus.CounterValue},
+	{"logical_sectors_written", 0x01, 24, false, prometheus.CounterValue},
+	{"write_commands", 0x01, 32, false, prometheus.CounterValue},
+	{"logical_sectors_read", 0x01, 40, false, prometheus.CounterValue},
+	{"read_commands", 0x01, 48, false, prometheus.CounterValue},
+	{"pending_errors", 0x01, 64, false, prometheus.GaugeValue},
+	{"workload_utilization", 0x01, 72, false, prometheus.GaugeValue},
+	// free-fall statistics
+	{"free_fall_events", 0x02, 8, false, prometheus.CounterValue},
+	{"overlimit_shock_events", 0x02, 16, false, prometheus.CounterValue},
+	// rotating media statistics
+	{"spindle_motor_power_on_hours", 0x03, 8, false, prometheus.CounterValue},
+	{"head_flying_hours", 0x03, 16, false, prometheus.CounterValue},
+	{"head_load_events", 0x03, 24, false, prometheus.CounterValue},
+	{"reallocated_logical_sectors", 0x03, 32, false, prometheus.GaugeValue},
+	{"read_recovery_attempts", 0x03, 40, false, prometheus.CounterValue},
+	{"mechanical_start_failures", 0x03, 48, false, prometheus.CounterValue},
+	{"reallocation_candidate_logical_sectors", 0x03, 56, false, prometheus.GaugeValue},
+	{"high_priority_unload_events", 0x03, 64, false, prometheus.CounterValue},
+	// general errors statistics
+	{"reported_uncorrectable_errors", 0x04, 8, false, prometheus.CounterValue},
+	{"resets_between_command_acceptance_and_completion", 0x04, 16, false, prometheus.CounterValue},
+	// temperature statistics
+	{"current_temperature_celsius", 0x05, 8, true, prometheus.GaugeValue},
+	{"average_short_term_temperature_celsius", 0x05, 16, true, prometheus.GaugeValue},
+	{"average_long_term_temperature_celsius", 0x05, 24, true, prometheus.GaugeValue},
+	{"highest_temperature_celsius", 0x05, 32, true, prometheus.GaugeValue},
+	{"lowest_temperature_celsius", 0x05, 40, true, prometheus.GaugeValue},
+	{"time_over_temperature_minutes", 0x05, 80, false, prometheus.CounterValue},
+	{"specified_max_temperature_celsius", 0x05, 88, true, prometheus.GaugeValue},
+	{"time_under_temperature_minutes", 0x05, 96, false, prometheus.CounterValue},
+	{"specified_min_temperature_celsius", 0x05, 104, true, prometheus.GaugeValue},
+	// transport statistics
+	{"hardware_resets", 0x06, 8, false, prometheus.CounterValue},
+	{"asr_events", 0x06, 16, false, prometheus.CounterValue},
+	{"interface_crc_errors", 0x06, 24, false, prometheus.CounterValue},
+	// solid state device statistics
+	{"percentage_used_endurance", 0x07, 8, false, prometheus.GaugeValue},
+}
+
+func list_sata_devstat_metrics() (out map[string]*prometheus.Desc) {
+	out = make(map[string]*prometheus.Desc)
+	for _, f := range ata_devstat_fields {
+		out[metric_sata_devstat+f.name] = prometheus.NewDesc(metric_sata_devstat+f.name, "", tags_dev_only, nil)
+	}
+	return
+}
+
+// devstatPages reads the list of supported pages from page 00h and keeps the ones
+// with known statistics
+func (d *SataDev) devstatPages() (pages []uint8) {
+	buf := make([]byte, ata_log_page)
+	if err := ataReadLogExt(d.fd, ata_log_device_statistics, 0, buf); err != nil {
+		slog.Debug("failed to read device statistics log", "dev", d.name, "err", err)
+		return
+	}
+	n := min(int(buf[8]), ata_log_page-9)
+	for _, page := range buf[9 : 9+n] {
+		if page != 0 && slices.ContainsFunc(ata_devstat_fields, func(f ataDevstatField) bool { return f.page == page }) {
+			pages = append(pages, page)
+		}
+	}
+	return
+}
+
+func (d *SataDev) devstatMetrics() (out []PromValue) {
+	if d.fd < 0 {
+		return
+	}
+	buf := make([]byte, ata_log_page)
+	for _, page := range d.devstat_pages {
+		if err := ataReadLogExt(d.fd, ata_log_device_statistics, uint16(page), buf); err != nil {
+			slog.Debug("failed to read device statistics page", "dev", d.name, "page", page, "err", err)
+			continue
+		}
+		for name, v := range ParseAtaDeviceStatistics(page, buf) {
+			out = append(out, PromValue{
+				Desc:  sata_metrics[metric_sata_devstat+name],
+				Type:  v.Type,
+				Value: v.Value,
+				Tags:  []string{d.name},
+			})
+		}
+	}
+	return
+}
+
+// ParseAtaDeviceStatistics decodes the supported and valid statistics of a page of
+// the Device Statistics log
+func ParseAtaDeviceStatistics(page uint8, buf []byte) (out map[string]logValue) {
+	out = make(map[string]logValue)
+	if len(buf) < ata_log_page || buf[2] != page {
+		return
+	}
+	for _, f := range ata_devstat_fields {
+		if f.page != page {
+			continue
+		}
+		qword := binary.LittleEndian.Uint64(buf[f.offset : f.offset+8])
+		if qword&devstat_supported == 0 || qword&devstat_valid == 0 {
+			continue
+		}
+		value := float64(qword & devstat_value_mask)
+		if f.signed {
+			value = float64(int8(qword))
+		}
+		out[f.name] = logValue{f.typ, value}
+	}
+	return
+}
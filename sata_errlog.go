@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/binary"
+	"log/slog"
+
+	"github.com/anatol/smart.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SMART error log (01h) and Extended Comprehensive SMART error log (03h), ACS-3 A.7 and A.4
+
+const (
+	ata_log_ext_error = 0x03
+
+	ata_summary_error_entries   = 5
+	ata_ext_error_entry_size    = 124
+	ata_ext_error_page_entries  = 4
+	ata_ext_error_max_pages     = 64
+	ata_summary_error_data      = 60 // offset of the error data structure in a summary entry
+	ata_ext_error_data          = 90 // offset of the error data structure in an extended entry
+	ata_summary_error_data_size = 30
+	ata_ext_error_data_size     = 34
+
+	sataErrorLogCount     = metric_sata + "error_log_count_total"
+	sataErrorLogLastHours = metric_sata + "error_log_last_power_on_hours"
+	sataErrorLogErrors    = metric_sata + "error_log_errors_total"
+)
+
+// bits of the error register
+var ata_error_types = []struct {
+	mask uint8
+	name string
+}{
+	{0x80, "ICRC"},
+	{0x40, "UNC"},
+	{0x10, "IDNF"},
+	{0x04, "ABRT"},
+}
+
+func list_sata_error_log_metrics() map[string]*prometheus.Desc {
+	return map[string]*prometheus.Desc{
+		sataErrorLogCount:     prometheus.NewDesc(sataErrorLogCount, "", tags_dev_only, nil),
+		sataErrorLogLastHours: prometheus.NewDesc(sataErrorLogLastHours, "", tags_dev_only, nil),
+		sataErrorLogErrors:    prometheus.NewDesc(sataErrorLogErrors, "", tags_dev_type, nil),
+	}
+}
+
+type ataErrorEntry struct {
+	Number uint64 // device error count when the error was logged
+	Error  uint8  // error register
+	Hours  uint16 // power-on hours when the error was logged
+}
+
+// ataErrorLog returns the logged errors, most recent first, from the ring buffer in
+// data holding entries of size bytes with the error data at data_offset. index is
+// the 1-based most recent entry.
+func ataErrorLog(data []byte, size, data_offset, data_size, index int, count uint16) (out []ataErrorEntry) {
+	entries := len(data) / size
+	if index == 0 || index > entries {
+		return
+	}
+	for i := range min(int(count), entries) {
+		pos := (index - 1 - i + entries) % entries
+		entry := data[pos*size : (pos+1)*size]
+		if isZero(entry) {
+			break
+		}
+		err_data := entry[data_offset : data_offset+data_size]
+		out = append(out, ataErrorEntry{
+			Number: uint64(count) - uint64(i),
+			Error:  err_data[1],
+			Hours:  binary.LittleEndian.Uint16(err_data[data_size-2:]),
+		})
+	}
+	return
+}
+
+// ParseAtaErrorLogSummary decodes the SMART error log read by smart.go
+func ParseAtaErrorLogSummary(log *smart.AtaSmartErrorLogSummary) ([]ataErrorEntry, uint16) {
+	data := make([]byte, 0, ata_summary_error_entries*len(log.LogData[0]))
+	for _, entry := range log.LogData {
+		data = append(data, entry[:]...)
+	}
+	return ataErrorLog(data, len(log.LogData[0]), ata_summary_error_data, ata_summary_error_data_size, int(log.LogIndex), log.ErrorCount), log.ErrorCount
+}
+
+// ParseAtaExtErrorLog decodes the pages of the Extended Comprehensive SMART error log,
+// the index and device error count are taken from the first page
+func ParseAtaExtErrorLog(buf []byte) ([]ataErrorEntry, uint16) {
+	if len(buf) < ata_log_page {
+		return nil, 0
+	}
+	index := int(binary.LittleEndian.Uint16(buf[2:4]))
+	count := binary.LittleEndian.Uint16(buf[500:502])
+	var data []byte
+	for page := 0; page+ata_log_page <= len(buf); page += ata_log_page {
+		data = append(data, buf[page+4:page+4+ata_ext_error_page_entries*ata_ext_error_entry_size]...)
+	}
+	return ataErrorLog(data, ata_ext_error_entry_size, ata_ext_error_data, ata_ext_error_data_size, index, count), count
+}
+
+// readErrorLog prefers the extended log, which keeps more entries and 48-bit LBAs
+func (d *SataDev) readErrorLog() ([]ataErrorEntry, uint16, error) {
+	if d.fd >= 0 {
+		pages, err := ataGpLogPages(d.fd, ata_log_ext_error)
+		if err == nil && pages > 0 {
+			buf := make([]byte, min(pages, ata_ext_error_max_pages)*ata_log_page)
+			if err = ataReadLogExt(d.fd, ata_log_ext_error, 0, buf); err == nil {
+				entries, count := ParseAtaExtErrorLog(buf)
+				return entries, count, nil
+			}
+		}
+		if err != nil {
+			slog.Debug("failed to read extended error log", "dev", d.name, "err", err)
+		}
+	}
+	log, err := d.dev.ReadSMARTErrorLogSummary()
+	if err != nil {
+		return nil, 0, err
+	}
+	entries, count := ParseAtaErrorLogSummary(log)
+	return entries, count, nil
+}
+
+// errorLogMetrics exports the ATA error log count and the errors logged since the last scrape
+func (d *SataDev) errorLogMetrics() (out []PromValue) {
+	entries, count, err := d.readErrorLog()
+	if err != nil {
+		slog.Debug("failed to read error log", "dev", d.name, "err", err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range entries {
+		if e.Number <= d.last_error_count {
+			continue
+		}
+		for _, t := range ata_error_types {
+			if e.Error&t.mask != 0 {
+				d.error_types[t.name]++
+			}
+		}
+	}
+	d.last_error_count = max(d.last_error_count, uint64(count))
+
+	template := PromValue{
+		Desc:  sata_metrics[sataErrorLogCount],
+		Type:  prometheus.CounterValue,
+		Value: float64(count),
+		Tags:  []string{d.name},
+	}
+	out = append(out, template)
+	template.Desc = sata_metrics[sataErrorLogErrors]
+	for _, t := range ata_error_types {
+		template.Tags = []string{d.name, t.name}
+		template.Value = float64(d.error_types[t.name])
+		out = append(out, template)
+	}
+	if len(entries) != 0 {
+		template.Desc = sata_metrics[sataErrorLogLastHours]
+		template.Type = prometheus.GaugeValue
+		template.Tags = []string{d.name}
+		template.Value = float64(entries[0].Hours)
+		out = append(out, template)
+	}
+	return
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SATA Phy Event Counters log (GP log 11h), SATA 3.3 13.7.7
+
+const (
+	ata_log_phy_event = 0x11
+
+	sataPhyEvent = metric_sata + "phy_event_total"
+)
+
+var tags_dev_counter = []string{tag_dev, "counter"}
+
+var sata_phy_event_counters = map[uint16]string{
+	0x001: "command_failed_icrc",
+	0x002: "r_err_data_fis",
+	0x003: "r_err_d2h_data_fis",
+	0x004: "r_err_h2d_data_fis",
+	0x005: "r_err_non_data_fis",
+	0x006: "r_err_d2h_non_data_fis",
+	0x007: "r_err_h2d_non_data_fis",
+	0x008: "d2h_non_data_fis_retries",
+	0x009: "phy_rdy_to_phy_nrdy",
+	0x00a: "d2h_register_fis_comreset",
+	0x00b: "h2d_fis_crc_errors",
+	0x00d: "h2d_fis_non_crc_errors",
+	0x00f: "r_err_h2d_data_fis_crc",
+	0x010: "r_err_h2d_data_fis_non_crc",
+	0x012: "r_err_h2d_non_data_fis_crc",
+	0x013: "r_err_h2d_non_data_fis_non_crc",
+}
+
+func list_sata_phy_metrics() map[string]*prometheus.Desc {
+	return map[string]*prometheus.Desc{
+		sataPhyEvent: prometheus.NewDesc(sataPhyEvent, "", tags_dev_counter, nil),
+	}
+}
+
+// ParseSataPhyEvents decodes the counters of the Phy Event Counters log. Each counter
+// is a 16-bit identifier followed by its value, whose size in words is in bits 14:12
+// of the identifier. The list ends with identifier 0.
+func ParseSataPhyEvents(buf []byte) (out map[string]uint64) {
+	out = make(map[string]uint64)
+	if len(buf) < ata_log_page {
+		return
+	}
+	// the last byte is the checksum
+	for i := 4; i+2 <= ata_log_page-1; {
+		id := binary.LittleEndian.Uint16(buf[i:])
+		if id == 0 {
+			break
+		}
+		size := int(id>>12&0x7) * 2
+		if size == 0 || i+2+size > ata_log_page-1 {
+			break
+		}
+		value := leUint(buf[i+2 : i+2+size])
+		i += 2 + size
+
+		code := id & 0xfff
+		name, ok := sata_phy_event_counters[code]
+		if !ok {
+			name = fmt.Sprintf("0x%03x", code)
+		}
+		if id&0x8000 != 0 {
+			name = fmt.Sprintf("vendor_0x%03x", code)
+		}
+		out[name] = value
+	}
+	return
+}
+
+func (d *SataDev) phyEventMetrics() (out []PromValue) {
+	if d.fd < 0 {
+		return
+	}
+	buf := make([]byte, ata_log_page)
+	// features 0, reading does not reset the counters
+	if err := ataReadLogExt(d.fd, ata_log_phy_event, 0, buf); err != nil {
+		slog.Debug("failed to read phy event counters", "dev", d.name, "err", err)
+		return
+	}
+	template := PromValue{
+		Desc: sata_metrics[sataPhyEvent],
+		Type: prometheus.CounterValue,
+	}
+	for name, value := range ParseSataPhyEvents(buf) {
+		template.Tags = []string{d.name, name}
+		template.Value = float64(value)
+		out = append(out, template)
+	}
+	return
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SCT Status and SCT Data Table temperature history, ACS-3 8.3.2 and 8.3.5.2
+
+const (
+	ata_log_sct_status = 0xe0 // SCT command on write, SCT status on read
+	ata_log_sct_data   = 0xe1
+
+	sct_action_data_table = 5
+	sct_function_read     = 1
+	sct_table_temperature = 2
+
+	sct_temperature_invalid = -128
+
+	sataSctTemperature      = metric_sata + "sct_temperature_celsius"
+	sataSctPowerCycleMin    = metric_sata + "sct_power_cycle_min_temperature_celsius"
+	sataSctPowerCycleMax    = metric_sata + "sct_power_cycle_max_temperature_celsius"
+	sataSctLifetimeMin      = metric_sata + "sct_lifetime_min_temperature_celsius"
+	sataSctLifetimeMax      = metric_sata + "sct_lifetime_max_temperature_celsius"
+	sataSctOverLimit        = metric_sata + "sct_over_limit_total"
+	sataSctUnderLimit       = metric_sata + "sct_under_limit_total"
+	sataSctTemperatureLimit = metric_sata + "sct_temperature_limit_celsius"
+	sataSctHistoryMax       = metric_sata + "sct_temperature_history_max_celsius"
+	sataSctHistoryMin       = metric_sata + "sct_temperature_history_min_celsius"
+	sataSctHistoryInterval  = metric_sata + "sct_temperature_history_interval_seconds"
+	sataSctHistorySpan      = metric_sata + "sct_temperature_history_span_seconds"
+)
+
+var tags_dev_limit = []string{tag_dev, "limit"}
+
+func list_sata_sct_metrics() (out map[string]*prometheus.Desc) {
+	out = make(map[string]*prometheus.Desc)
+	dev_metrics := []string{
+		sataSctTemperature,
+		sataSctPowerCycleMin,
+		sataSctPowerCycleMax,
+		sataSctLifetimeMin,
+		sataSctLifetimeMax,
+		sataSctOverLimit,
+		sataSctUnderLimit,
+		sataSctHistoryMax,
+		sataSctHistoryMin,
+		sataSctHistoryInterval,
+		sataSctHistorySpan,
+	}
+	for _, metric_name := range dev_metrics {
+		out[metric_name] = prometheus.NewDesc(metric_name, "", tags_dev_only, nil)
+	}
+	out[sataSctTemperatureLimit] = prometheus.NewDesc(sataSctTemperatureLimit, "", tags_dev_limit, nil)
+	return
+}
+
+type sctStatus struct {
+	Temperature     int8
+	PowerCycleMin   int8
+	PowerCycleMax   int8
+	LifetimeMin     int8
+	LifetimeMax     int8
+	OverLimitCount  uint32
+	UnderLimitCount uint32
+}
+
+// ParseSctStatus decodes the SCT status response read from log E0h
+func ParseSctStatus(buf []byte) (s sctStatus, err error) {
+	if len(buf) < ata_log_page {
+		err = fmt.Errorf("short SCT status: %d bytes", len(buf))
+		return
+	}
+	if version := binary.LittleEndian.Uint16(buf[0:2]); version < 2 {
+		err = fmt.Errorf("unsupported SCT status format version %d", version)
+		return
+	}
+	s.Temperature = int8(buf[200])
+	s.PowerCycleMin = int8(buf[201])
+	s.PowerCycleMax = int8(buf[202])
+	s.LifetimeMin = int8(buf[203])
+	s.LifetimeMax = int8(buf[204])
+	s.OverLimitCount = binary.LittleEndian.Uint32(buf[206:210])
+	s.UnderLimitCount = binary.LittleEndian.Uint32(buf[210:214])
+	return
+}
+
+type sctTemperatureHistory struct {
+	Interval     uint16 // minutes between samples
+	MaxOperating int8
+	OverLimit    int8
+	MinOperating int8
+	UnderLimit   int8
+	Samples      []int8 // valid samples, oldest first
+}
+
+// ParseSctTemperatureHistory decodes the temperature history table read from log E1h
+func ParseSctTemperatureHistory(buf []byte) (h sctTemperatureHistory) {
+	if len(buf) < ata_log_page {
+		return
+	}
+	h.Interval = binary.LittleEndian.Uint16(buf[4:6])
+	h.MaxOperating = int8(buf[6])
+	h.OverLimit = int8(buf[7])
+	h.MinOperating = int8(buf[8])
+	h.UnderLimit = int8(buf[9])
+	size := min(int(binary.LittleEndian.Uint16(buf[30:32])), len(buf)-34)
+	index := int(binary.LittleEndian.Uint16(buf[32:34]))
+	if size <= 0 {
+		return
+	}
+	ring := buf[34 : 34+size]
+	for i := range size {
+		// the entry after index is the oldest
+		t := int8(ring[(index+1+i)%size])
+		if t != sct_temperature_invalid {
+			h.Samples = append(h.Samples, t)
+		}
+	}
+	return
+}
+
+func (d *SataDev) readSctStatus() (sctStatus, error) {
+	buf := make([]byte, ata_log_page)
+	if err := ataSmartRead(d.fd, smart_read_log, ata_log_sct_status, buf); err != nil {
+		return sctStatus{}, err
+	}
+	return ParseSctStatus(buf)
+}
+
+// readSctTemperatureHistory asks for the temperature history table with a SCT data
+// table command, then reads it from the data transfer log
+func (d *SataDev) readSctTemperatureHistory() (h sctTemperatureHistory, err error) {
+	cmd := make([]byte, ata_log_page)
+	binary.LittleEndian.PutUint16(cmd[0:2], sct_action_data_table)
+	binary.LittleEndian.PutUint16(cmd[2:4], sct_function_read)
+	binary.LittleEndian.PutUint16(cmd[4:6], sct_table_temperature)
+	if err = ataSmartWriteLog(d.fd, ata_log_sct_status, cmd); err != nil {
+		return
+	}
+	buf := make([]byte, ata_log_page)
+	if err = ataSmartRead(d.fd, smart_read_log, ata_log_sct_data, buf); err != nil {
+		return
+	}
+	return ParseSctTemperatureHistory(buf), nil
+}
+
+// sctMetrics exports SCT status temperatures and the temperature history, whose
+// max/min cover excursions between scrapes as long as the history spans the scrape
+// interval
+func (d *SataDev) sctMetrics() (out []PromValue) {
+	if d.fd < 0 || !d.sct {
+		return
+	}
+	template := PromValue{
+		Type: prometheus.GaugeValue,
+		Tags: []string{d.name},
+	}
+	status, err := d.readSctStatus()
+	if err != nil {
+		slog.Debug("failed to read sct status", "dev", d.name, "err", err)
+		return
+	}
+	temperatures := map[string]int8{
+		sataSctTemperature:   status.Temperature,
+		sataSctPowerCycleMin: status.PowerCycleMin,
+		sataSctPowerCycleMax: status.PowerCycleMax,
+		sataSctLifetimeMin:   status.LifetimeMin,
+		sataSctLifetimeMax:   status.LifetimeMax,
+	}
+	for name, value := range temperatures {
+		if value == sct_temperature_invalid {
+			continue
+		}
+		template.Desc = sata_metrics[name]
+		template.Value = float64(value)
+		out = append(out, template)
+	}
+	template.Type = prometheus.CounterValue
+	template.Desc = sata_metrics[sataSctOverLimit]
+	template.Value = float64(status.OverLimitCount)
+	out = append(out, template)
+	template.Desc = sata_metrics[sataSctUnderLimit]
+	template.Value = float64(status.UnderLimitCount)
+	out = append(out, template)
+
+	history, err := d.readSctTemperatureHistory()
+	if err != nil {
+		slog.Debug("failed to read sct temperature history", "dev", d.name, "err", err)
+		return
+	}
+	template.Type = prometheus.GaugeValue
+	template.Desc = sata_metrics[sataSctTemperatureLimit]
+	limits := map[string]int8{
+		"max_operating": history.MaxOperating,
+		"over":          history.OverLimit,
+		"min_operating": history.MinOperating,
+		"under":         history.UnderLimit,
+	}
+	for limit, value := range limits {
+		if value == sct_temperature_invalid {
+			continue
+		}
+		template.Tags = []string{d.name, limit}
+		template.Value = float64(value)
+		out = append(out, template)
+	}
+	template.Tags = []string{d.name}
+	if len(history.Samples) != 0 {
+		template.Desc = sata_metrics[sataSctHistoryMax]
+		template.Value = float64(slices.Max(history.Samples))
+		out = append(out, template)
+		template.Desc = sata_metrics[sataSctHistoryMin]
+		template.Value = float64(slices.Min(history.Samples))
+		out = append(out, template)
+	}
+	interval := float64(history.Interval) * 60
+	template.Desc = sata_metrics[sataSctHistoryInterval]
+	template.Value = interval
+	out = append(out, template)
+	template.Desc = sata_metrics[sataSctHistorySpan]
+	template.Value = interval * float64(len(history.Samples))
+	out = append(out, template)
+	return
+}
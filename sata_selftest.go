@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+
+	"github.com/anatol/smart.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SMART self-test log (06h) and Extended SMART self-test log (07h), ACS-3 A.15 and A.21
+
+const (
+	ata_log_ext_self_test = 0x07
+
+	ata_self_test_entry_size       = 24
+	ata_ext_self_test_entry_size   = 26
+	ata_ext_self_test_page_entries = 19
+	ata_ext_self_test_max_pages    = 16
+
+	// off-line routine subcommands of SMART EXECUTE OFF-LINE IMMEDIATE
+	ata_self_test_short    = 0x01
+	ata_self_test_extended = 0x02
+
+	// self-test execution status and off-line data collection capability of the
+	// SMART data structure
+	ata_self_test_status_offset = 363
+	ata_self_test_in_progress   = 0xf
+	ata_offline_cap_offset      = 367
+	ata_offline_cap_immediate   = 1 << 0
+	ata_offline_cap_self_test   = 1 << 4
+
+	sataSelfTestStatus     = metric_sata + "self_test_current_status"
+	sataSelfTestRemaining  = metric_sata + "self_test_current_remaining_percent"
+	sataSelfTestLatest     = metric_sata + "self_test_latest_info"
+	sataSelfTestLastResult = metric_sata + "self_test_last_result"
+	sataSelfTestLastHours  = metric_sata + "self_test_last_power_on_hours"
+	sataSelfTestLastLba    = metric_sata + "self_test_last_failed_lba"
+)
+
+// self-test types by subcommand, captive tests (bit 7 set) are reported as their
+// off-line counterparts
+var ata_self_test_types = map[uint8]string{
+	0x00: "offline",
+	0x01: "short",
+	0x02: "extended",
+	0x03: "conveyance",
+	0x04: "selective",
+}
+
+func list_sata_self_test_metrics() map[string]*prometheus.Desc {
+	return map[string]*prometheus.Desc{
+		sataSelfTestStatus:     prometheus.NewDesc(sataSelfTestStatus, "", tags_dev_only, nil),
+		sataSelfTestRemaining:  prometheus.NewDesc(sataSelfTestRemaining, "", tags_dev_only, nil),
+		sataSelfTestLatest:     prometheus.NewDesc(sataSelfTestLatest, "", tags_dev_type, nil),
+		sataSelfTestLastResult: prometheus.NewDesc(sataSelfTestLastResult, "", tags_dev_type, nil),
+		sataSelfTestLastHours:  prometheus.NewDesc(sataSelfTestLastHours, "", tags_dev_type, nil),
+		sataSelfTestLastLba:    prometheus.NewDesc(sataSelfTestLastLba, "", tags_dev_type, nil),
+	}
+}
+
+type ataSelfTestEntry struct {
+	Type   string
+	Status uint8 // self-test execution status, 0 is completed without error
+	Hours  uint16
+	LBA    uint64 // LBA of the first failure
+}
+
+// Failed tells if the test stopped on a failure, so that LBA is meaningful
+func (e ataSelfTestEntry) Failed() bool {
+	return e.Status >= 3 && e.Status <= 8
+}
+
+// ataSelfTestLog walks the ring of entries in data backwards from the 1-based most
+// recent entry index, the failing LBA is lba_size bytes at offset 5
+func ataSelfTestLog(data []byte, size, lba_size, index int) (out []ataSelfTestEntry) {
+	entries := len(data) / size
+	if index == 0 || index > entries {
+		return
+	}
+	for i := range entries {
+		pos := (index - 1 - i + entries) % entries
+		entry := data[pos*size : (pos+1)*size]
+		if isZero(entry) {
+			break
+		}
+		typ, ok := ata_self_test_types[entry[0]&0x7f]
+		if !ok {
+			typ = fmt.Sprintf("0x%02x", entry[0])
+		}
+		out = append(out, ataSelfTestEntry{
+			Type:   typ,
+			Status: entry[1] >> 4,
+			Hours:  binary.LittleEndian.Uint16(entry[2:4]),
+			LBA:    leUint(entry[5 : 5+lba_size]),
+		})
+	}
+	return
+}
+
+// ParseAtaSelfTestLog decodes the SMART self-test log read by smart.go, newest first
+func ParseAtaSelfTestLog(log *smart.AtaSmartSelfTestLog) []ataSelfTestEntry {
+	data := make([]byte, 0, len(log.Entry)*ata_self_test_entry_size)
+	for _, e := range log.Entry {
+		data = append(data, e.LBA_7, e.Status)
+		data = binary.LittleEndian.AppendUint16(data, e.LifeTimestamp)
+		data = append(data, e.Checkpoint)
+		data = binary.LittleEndian.AppendUint32(data, e.LBA)
+		data = append(data, e.VendorSpecific[:]...)
+	}
+	return ataSelfTestLog(data, ata_self_test_entry_size, 4, int(log.Index))
+}
+
+// ParseAtaExtSelfTestLog decodes the pages of the Extended SMART self-test log, newest
+// first. The index is taken from the first page.
+func ParseAtaExtSelfTestLog(buf []byte) []ataSelfTestEntry {
+	if len(buf) < ata_log_page {
+		return nil
+	}
+	index := int(binary.LittleEndian.Uint16(buf[2:4]))
+	var data []byte
+	for page := 0; page+ata_log_page <= len(buf); page += ata_log_page {
+		data = append(data, buf[page+4:page+4+ata_ext_self_test_page_entries*ata_ext_self_test_entry_size]...)
+	}
+	return ataSelfTestLog(data, ata_ext_self_test_entry_size, 6, index)
+}
+
+// readSelfTestLog prefers the extended log, which has 48-bit LBAs
+func (d *SataDev) readSelfTestLog() ([]ataSelfTestEntry, error) {
+	if d.fd >= 0 {
+		pages, err := ataGpLogPages(d.fd, ata_log_ext_self_test)
+		if err == nil && pages > 0 {
+			buf := make([]byte, min(pages, ata_ext_self_test_max_pages)*ata_log_page)
+			if err = ataReadLogExt(d.fd, ata_log_ext_self_test, 0, buf); err == nil {
+				return ParseAtaExtSelfTestLog(buf), nil
+			}
+		}
+		if err != nil {
+			slog.Debug("failed to read extended self-test log", "dev", d.name, "err", err)
+		}
+	}
+	log, err := d.dev.ReadSMARTSelfTestLog()
+	if err != nil {
+		return nil, err
+	}
+	return ParseAtaSelfTestLog(log), nil
+}
+
+// selfTestStatus returns the self-test execution status and the off-line data
+// collection capability bytes of the SMART data, which smart.go does not expose
+func (d *SataDev) selfTestStatus() (status, capability uint8, err error) {
+	if d.fd < 0 {
+		return 0, 0, fmt.Errorf("sata dev %s is not opened", d.name)
+	}
+	buf := make([]byte, ata_log_page)
+	if err = ataSmartRead(d.fd, smart_read_data, 0, buf); err != nil {
+		return 0, 0, err
+	}
+	return buf[ata_self_test_status_offset], buf[ata_offline_cap_offset], nil
+}
+
+// selfTestMetrics exports the execution status byte of the SMART data and the newest
+// entries of the self-test log
+func (d *SataDev) selfTestMetrics(page []byte) (out []PromValue) {
+	template := PromValue{
+		Type: prometheus.GaugeValue,
+		Tags: []string{d.name},
+	}
+	if len(page) > ata_self_test_status_offset {
+		status := page[ata_self_test_status_offset]
+		template.Desc = sata_metrics[sataSelfTestStatus]
+		template.Value = float64(status >> 4)
+		out = append(out, template)
+		template.Desc = sata_metrics[sataSelfTestRemaining]
+		template.Value = float64(status&0xf) * 10
+		out = append(out, template)
+	}
+
+	entries, err := d.readSelfTestLog()
+	if err != nil {
+		slog.Debug("failed to read self-test log", "dev", d.name, "err", err)
+		return
+	}
+	if len(entries) != 0 {
+		template.Desc = sata_metrics[sataSelfTestLatest]
+		template.Tags = []string{d.name, entries[0].Type}
+		template.Value = 0
+		out = append(out, template)
+	}
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if seen[e.Type] {
+			continue
+		}
+		seen[e.Type] = true
+		template.Tags = []string{d.name, e.Type}
+		template.Desc = sata_metrics[sataSelfTestLastResult]
+		template.Value = float64(e.Status)
+		out = append(out, template)
+		template.Desc = sata_metrics[sataSelfTestLastHours]
+		template.Value = float64(e.Hours)
+		out = append(out, template)
+		if e.Failed() {
+			template.Desc = sata_metrics[sataSelfTestLastLba]
+			template.Value = float64(e.LBA)
+			out = append(out, template)
+		}
+	}
+	return
+}
+
+// SelfTestAge compares the power-on hours with the timestamp of the newest entry of
+// kind that was not aborted. The log only keeps the lower 16 bits of the hours.
+func (d *SataDev) SelfTestAge(kind string) (hours uint64, ok bool, err error) {
+	attrs, err := d.dev.ReadGenericAttributes()
+	if err != nil {
+		return
+	}
+	entries, err := d.readSelfTestLog()
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.Type == kind && (e.Status == 0 || e.Failed()) {
+			return uint64(uint16(attrs.PowerOnHours) - e.Hours), true, nil
+		}
+	}
+	return
+}
+
+// StartSelfTest runs the test in off-line mode, so the drive keeps serving commands.
+// A test in progress is not aborted.
+func (d *SataDev) StartSelfTest(kind string) error {
+	var subcommand uint8
+	switch kind {
+	case "short":
+		subcommand = ata_self_test_short
+	case "extended":
+		subcommand = ata_self_test_extended
+	default:
+		return errUnknownTestKind
+	}
+	status, capability, err := d.selfTestStatus()
+	if err != nil {
+		return err
+	}
+	if capability&ata_offline_cap_immediate == 0 || capability&ata_offline_cap_self_test == 0 {
+		return errSelfTestNotSupported
+	}
+	if status>>4 == ata_self_test_in_progress {
+		return errSelfTestInProgress
+	}
+	return ataSmartExecuteOffline(d.fd, subcommand)
+}
@@ -1,23 +1,432 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+
 	"github.com/anatol/smart.go"
+	"github.com/dustin/go-humanize"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
 )
 
-// since smart.go does not fully support scsi, only info is provided. All metrics is not available
+// smart.go only provides INQUIRY and READ CAPACITY for scsi, everything else is read
+// from the log pages with our own LOG SENSE.
 
 type ScsiDev struct {
-	name string
-	dev  *smart.ScsiDevice
+	name  string
+	dev   *smart.ScsiDevice
+	fd    int
+	info  []string
+	pages []uint8
+}
+
+const (
+	metric_scsi      = metric_head + "scsi_"
+	scsi_info        = metric_scsi + "Info"
+	scsiTemperature  = metric_scsi + "temperature_celsius"
+	scsiPowerOnHours = metric_scsi + "power_on_hours_total"
+	scsiBytesRead    = metric_scsi + "read_bytes_total"
+	scsiBytesWritten = metric_scsi + "written_bytes_total"
+	scsiErrors       = metric_scsi + "errors_total"
+	scsiNonMedium    = metric_scsi + "non_medium_errors_total"
+
+	scsiReferenceTemperature      = metric_scsi + "reference_temperature_celsius"
+	scsiSpecifiedStartStopCycles  = metric_scsi + "specified_start_stop_cycles"
+	scsiStartStopCycles           = metric_scsi + "start_stop_cycles_total"
+	scsiSpecifiedLoadUnloadCycles = metric_scsi + "specified_load_unload_cycles"
+	scsiLoadUnloadCycles          = metric_scsi + "load_unload_cycles_total"
+	scsiIEAsc                     = metric_scsi + "informational_exception_asc"
+	scsiIEAscq                    = metric_scsi + "informational_exception_ascq"
+	scsiFailurePredicted          = metric_scsi + "failure_predicted"
+	scsiSelfTestLastResult        = metric_scsi + "self_test_last_result"
+	scsiSelfTestLastType          = metric_scsi + "self_test_last_type"
+	scsiSelfTestLastFailedLBA     = metric_scsi + "self_test_last_failed_lba"
+	scsiGrownDefects              = metric_scsi + "grown_defects"
+	scsiPercentUsed               = metric_scsi + "percent_used"
+	scsiInvalidDwordCount         = metric_scsi + "invalid_dwords_total"
+	scsiRunningDisparityErrors    = metric_scsi + "running_disparity_errors_total"
+	scsiLossOfDwordSync           = metric_scsi + "loss_of_dword_sync_total"
+	scsiPhyResetProblem           = metric_scsi + "phy_reset_problems_total"
+)
+
+const (
+	scsi_log_sense        = 0x4d
+	scsi_read_capacity_16 = 0x9e
+
+	scsi_page_supported   = 0x00
+	scsi_page_write_err   = 0x02
+	scsi_page_read_err    = 0x03
+	scsi_page_verify_err  = 0x05
+	scsi_page_non_medium  = 0x06
+	scsi_page_temperature = 0x0d
+	scsi_page_start_stop  = 0x0e
+	scsi_page_self_test   = 0x10
+	scsi_page_ssd         = 0x11
+	scsi_page_bg_scan     = 0x15
+	scsi_page_port        = 0x18
+	scsi_page_ie          = 0x2f
+)
+
+var (
+	tags_scsi_errors = []string{tag_dev, "direction", "kind"}
+	tags_scsi_phy    = []string{tag_dev, "port", "phy"}
+	tags_scsi_info   = []string{
+		tag_dev,
+		"Vendor",
+		"Product",
+		"Revision",
+		"Serial_Number",
+		"User_Capacity",
+		"Logical_Block_Size",
+		"Physical_Block_Size",
+		"Manufactured",
+	}
+	scsi_metrics = list_scsi_metrics()
+)
+
+func list_scsi_metrics() (out map[string]*prometheus.Desc) {
+	out = make(map[string]*prometheus.Desc)
+	normal_metrics := []string{
+		scsiTemperature,
+		scsiPowerOnHours,
+		scsiBytesRead,
+		scsiBytesWritten,
+		scsiNonMedium,
+		scsiReferenceTemperature,
+		scsiSpecifiedStartStopCycles,
+		scsiStartStopCycles,
+		scsiSpecifiedLoadUnloadCycles,
+		scsiLoadUnloadCycles,
+		scsiIEAsc,
+		scsiIEAscq,
+		scsiFailurePredicted,
+		scsiSelfTestLastResult,
+		scsiSelfTestLastType,
+		scsiSelfTestLastFailedLBA,
+		scsiGrownDefects,
+		scsiPercentUsed,
+	}
+	for _, metric_name := range normal_metrics {
+		out[metric_name] = prometheus.NewDesc(metric_name, "", tags_dev_only, nil)
+	}
+
+	phy_metrics := []string{
+		scsiInvalidDwordCount,
+		scsiRunningDisparityErrors,
+		scsiLossOfDwordSync,
+		scsiPhyResetProblem,
+	}
+	for _, metric_name := range phy_metrics {
+		out[metric_name] = prometheus.NewDesc(metric_name, "", tags_scsi_phy, nil)
+	}
+	out[scsiErrors] = prometheus.NewDesc(scsiErrors, "", tags_scsi_errors, nil)
+	out[scsi_info] = prometheus.NewDesc(scsi_info, "", tags_scsi_info, nil)
+	return
+}
+
+func NewScsiDev(name string, smartdev *smart.ScsiDevice) (d *ScsiDev) {
+	d = &ScsiDev{name: name, dev: smartdev}
+	var err error
+	d.fd, err = openRaw(devPath(name))
+	if err != nil {
+		slog.Warn("failed to open scsi dev, log pages are not available", "dev", name, "err", err)
+		d.fd = -1
+	}
+	d.info = make([]string, len(tags_scsi_info))
+	d.info[0] = name
+	inq, err := d.dev.Inquiry()
+	if err == nil {
+		d.info[1] = string(bytes.TrimSpace(inq.VendorIdent[:]))
+		d.info[2] = string(bytes.TrimSpace(inq.ProductIdent[:]))
+		d.info[3] = string(bytes.TrimSpace(inq.ProductRev[:]))
+	}
+	serial, err := d.dev.SerialNumber()
+	if err == nil {
+		d.info[4] = strings.TrimSpace(serial)
+	}
+	capacity, logical, physical, err := d.readCapacity()
+	if err == nil {
+		d.info[5] = fmt.Sprintf("%s bytes [%s]", humanize.Comma(int64(capacity)), humanize.Bytes(capacity))
+		d.info[6] = strconv.FormatUint(logical, 10)
+		d.info[7] = strconv.FormatUint(physical, 10)
+	} else if capacity, err = d.dev.Capacity(); err == nil {
+		d.info[5] = fmt.Sprintf("%s bytes [%s]", humanize.Comma(int64(capacity)), humanize.Bytes(capacity))
+	}
+	d.pages, err = d.supportedPages()
+	if err != nil {
+		slog.Warn("failed to list scsi log pages", "dev", name, "err", err)
+	}
+	if params, err := d.logSense(scsi_page_start_stop); err == nil {
+		d.info[8] = parseScsiStartStop(params).ManufactureDate
+	}
+	return
+}
+
+func (d *ScsiDev) Name() string {
+	return d.name
+}
+
+func (d *ScsiDev) Type() string {
+	return d.dev.Type()
+}
+
+// Health is based on the informational exceptions, any additional sense code
+// reported there is a failure prediction
+func (d *ScsiDev) Health() (ok bool, reason string, err error) {
+	params, err := d.logSense(scsi_page_ie)
+	if err != nil {
+		return
+	}
+	asc, ascq, _, found := parseScsiInformationalExceptions(params)
+	if !found {
+		err = fmt.Errorf("no informational exceptions parameter")
+		return
+	}
+	return asc == 0, fmt.Sprintf("ASC 0x%02x ASCQ 0x%02x", asc, ascq), nil
+}
+
+func (d *ScsiDev) Close() error {
+	if d.fd >= 0 {
+		unix.Close(d.fd)
+	}
+	return d.dev.Close()
+}
+
+func (*ScsiDev) ListMetrics() map[string]*prometheus.Desc {
+	return scsi_metrics
+}
+
+func (d *ScsiDev) GetMetrics() (out []PromValue) {
+	template := PromValue{
+		Type: prometheus.GaugeValue,
+		Tags: []string{d.name},
+	}
+
+	current_temp := -1
+	if params, err := d.logSense(scsi_page_temperature); err == nil {
+		var reference_temp int
+		current_temp, reference_temp = parseScsiTemperature(params)
+		if reference_temp >= 0 {
+			template.Desc = scsi_metrics[scsiReferenceTemperature]
+			template.Value = float64(reference_temp)
+			out = append(out, template)
+		}
+	}
+
+	if params, err := d.logSense(scsi_page_ie); err == nil {
+		asc, ascq, ie_temp, ok := parseScsiInformationalExceptions(params)
+		if ok {
+			ie_metrics := map[string]uint8{
+				scsiIEAsc:  asc,
+				scsiIEAscq: ascq,
+			}
+			for name, value := range ie_metrics {
+				template.Desc = scsi_metrics[name]
+				template.Value = float64(value)
+				out = append(out, template)
+			}
+			template.Desc = scsi_metrics[scsiFailurePredicted]
+			template.Value = 0
+			if asc != 0 {
+				template.Value = 1
+			}
+			out = append(out, template)
+		}
+		// devices without the temperature page still report it here
+		if current_temp < 0 {
+			current_temp = ie_temp
+		}
+	}
+
+	if current_temp >= 0 {
+		template.Desc = scsi_metrics[scsiTemperature]
+		template.Value = float64(current_temp)
+		out = append(out, template)
+	}
+
+	if params, err := d.logSense(scsi_page_self_test); err == nil {
+		if tests := parseScsiSelfTests(params); len(tests) != 0 {
+			last := tests[0]
+			template.Type = prometheus.GaugeValue
+			template.Desc = scsi_metrics[scsiSelfTestLastResult]
+			template.Value = float64(last.Result)
+			out = append(out, template)
+			template.Desc = scsi_metrics[scsiSelfTestLastType]
+			template.Value = float64(last.Code)
+			out = append(out, template)
+			if last.FailedLBA != math.MaxUint64 {
+				template.Desc = scsi_metrics[scsiSelfTestLastFailedLBA]
+				template.Value = float64(last.FailedLBA)
+				out = append(out, template)
+			}
+		}
+	}
+
+	if params, err := d.logSense(scsi_page_ssd); err == nil {
+		if used, ok := parseScsiPercentUsed(params); ok {
+			template.Type = prometheus.GaugeValue
+			template.Desc = scsi_metrics[scsiPercentUsed]
+			template.Value = float64(used)
+			out = append(out, template)
+		}
+	}
+
+	if defects, err := d.grownDefects(); err == nil {
+		template.Type = prometheus.GaugeValue
+		template.Desc = scsi_metrics[scsiGrownDefects]
+		template.Value = float64(defects)
+		out = append(out, template)
+	}
+
+	if params, err := d.logSense(scsi_page_start_stop); err == nil {
+		ss := parseScsiStartStop(params)
+		template.Type = prometheus.GaugeValue
+		template.Desc = scsi_metrics[scsiSpecifiedStartStopCycles]
+		template.Value = float64(ss.SpecifiedStartStopCycles)
+		out = append(out, template)
+		template.Desc = scsi_metrics[scsiSpecifiedLoadUnloadCycles]
+		template.Value = float64(ss.SpecifiedLoadUnloadCycles)
+		out = append(out, template)
+
+		template.Type = prometheus.CounterValue
+		template.Desc = scsi_metrics[scsiStartStopCycles]
+		template.Value = float64(ss.StartStopCycles)
+		out = append(out, template)
+		template.Desc = scsi_metrics[scsiLoadUnloadCycles]
+		template.Value = float64(ss.LoadUnloadCycles)
+		out = append(out, template)
+	}
+
+	if params, err := d.logSense(scsi_page_bg_scan); err == nil {
+		// first 4 bytes of the status parameter are accumulated power on minutes
+		if p, ok := params[0x0000]; ok && len(p) >= 4 {
+			template.Type = prometheus.CounterValue
+			template.Desc = scsi_metrics[scsiPowerOnHours]
+			template.Value = float64(binary.BigEndian.Uint32(p)) / 60
+			out = append(out, template)
+		}
+	}
+
+	error_pages := []struct {
+		page      uint8
+		direction string
+		bytes     string
+	}{
+		{scsi_page_write_err, "write", scsiBytesWritten},
+		{scsi_page_read_err, "read", scsiBytesRead},
+		{scsi_page_verify_err, "verify", ""},
+	}
+	for _, ep := range error_pages {
+		params, err := d.logSense(ep.page)
+		if err != nil {
+			continue
+		}
+		template.Type = prometheus.CounterValue
+		if p, ok := params[0x0005]; ok && len(ep.bytes) != 0 {
+			template.Desc = scsi_metrics[ep.bytes]
+			template.Value = float64(beUint(p))
+			out = append(out, template)
+		}
+		template.Desc = scsi_metrics[scsiErrors]
+		for kind, v := range parseScsiErrorCounters(params) {
+			template.Tags = []string{d.name, ep.direction, kind}
+			template.Value = float64(v)
+			out = append(out, template)
+		}
+		template.Tags = []string{d.name}
+	}
+
+	if params, err := d.logSense(scsi_page_port); err == nil {
+		for _, phy := range parseScsiSasPhys(params) {
+			phy_metrics := map[string]uint32{
+				scsiInvalidDwordCount:      phy.InvalidDword,
+				scsiRunningDisparityErrors: phy.RunningDisparity,
+				scsiLossOfDwordSync:        phy.LossOfDwordSync,
+				scsiPhyResetProblem:        phy.PhyResetProblem,
+			}
+			template.Type = prometheus.CounterValue
+			template.Tags = []string{d.name, strconv.Itoa(int(phy.Port)), strconv.Itoa(int(phy.Phy))}
+			for name, value := range phy_metrics {
+				template.Desc = scsi_metrics[name]
+				template.Value = float64(value)
+				out = append(out, template)
+			}
+		}
+		template.Tags = []string{d.name}
+	}
+
+	if params, err := d.logSense(scsi_page_non_medium); err == nil {
+		if p, ok := params[0x0000]; ok {
+			template.Type = prometheus.CounterValue
+			template.Desc = scsi_metrics[scsiNonMedium]
+			template.Value = float64(beUint(p))
+			out = append(out, template)
+		}
+	}
+
+	template.Desc = scsi_metrics[scsi_info]
+	template.Type = prometheus.GaugeValue
+	template.Value = 0
+	template.Tags = d.info
+	out = append(out, template)
+	return
+}
+
+func (d *ScsiDev) readCapacity() (capacity, logical, physical uint64, err error) {
+	if d.fd < 0 {
+		err = fmt.Errorf("scsi dev %s is not opened", d.name)
+		return
+	}
+	buf := make([]byte, 32)
+	cdb := [16]byte{scsi_read_capacity_16, 0x10} // SERVICE ACTION IN(16), READ CAPACITY(16)
+	binary.BigEndian.PutUint32(cdb[10:14], uint32(len(buf)))
+	if err = scsiSendCdb(d.fd, cdb[:], sg_dxfer_from_dev, buf); err != nil {
+		return
+	}
+	logical = uint64(binary.BigEndian.Uint32(buf[8:12]))
+	physical = logical << (buf[13] & 0xf)
+	capacity = (binary.BigEndian.Uint64(buf[0:8]) + 1) * logical
+	return
 }
 
 const (
-	metric_scsi = metric_head + "scsi_"
-	scsi_info   = metric_scsi + "Info"
+	scsi_read_defect_10 = 0x37
+	scsi_read_defect_12 = 0xb7
 )
 
-func (d *ScsiDev) ListMetrics() map[string]*prometheus.Desc {
-	d.dev.Inquiry()
-	return nil
+// grownDefects returns the number of entries in the grown defect list
+func (d *ScsiDev) grownDefects() (uint64, error) {
+	if d.fd < 0 {
+		return 0, fmt.Errorf("scsi dev %s is not opened", d.name)
+	}
+	// only the header is needed, REQ_GLIST and bytes from index format
+	buf := make([]byte, 8)
+	cdb12 := [12]byte{scsi_read_defect_12, 0x08 | 0x04}
+	binary.BigEndian.PutUint32(cdb12[6:10], uint32(len(buf)))
+	var length uint64
+	if err := scsiSendCdb(d.fd, cdb12[:], sg_dxfer_from_dev, buf); err == nil {
+		length = uint64(binary.BigEndian.Uint32(buf[4:8]))
+	} else {
+		buf = buf[:4]
+		cdb10 := [10]byte{scsi_read_defect_10, 0, 0x08 | 0x04}
+		binary.BigEndian.PutUint16(cdb10[7:9], uint16(len(buf)))
+		if err = scsiSendCdb(d.fd, cdb10[:], sg_dxfer_from_dev, buf); err != nil {
+			return 0, err
+		}
+		length = uint64(binary.BigEndian.Uint16(buf[2:4]))
+	}
+	if buf[1]&0x08 == 0 {
+		return 0, fmt.Errorf("grown defect list is not valid")
+	}
+	// the device may answer with another format than the requested one
+	if buf[1]&0x07 == 0 {
+		return length / 4, nil
+	}
+	return length / 8, nil
 }
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+func (d *ScsiDev) supportedPages() ([]uint8, error) {
+	buf, err := d.logSenseRaw(scsi_page_supported)
+	if err != nil {
+		return nil, err
+	}
+	return buf[4:], nil
+}
+
+// logSense reads page and returns its parameters by parameter code. Pages not
+// listed as supported by the device are not requested at all.
+func (d *ScsiDev) logSense(page uint8) (map[uint16][]byte, error) {
+	if !slices.Contains(d.pages, page) {
+		return nil, fmt.Errorf("log page %#02x not supported", page)
+	}
+	buf, err := d.logSenseRaw(page)
+	if err != nil {
+		return nil, err
+	}
+	return parseScsiLogPage(page, buf)
+}
+
+func (d *ScsiDev) logSenseRaw(page uint8) ([]byte, error) {
+	if d.fd < 0 {
+		return nil, fmt.Errorf("scsi dev %s is not opened", d.name)
+	}
+	// read the header first to find out the length of the page
+	length := 4
+	for range 2 {
+		buf := make([]byte, length)
+		cdb := [10]byte{scsi_log_sense}
+		cdb[2] = 0x40 | page // PC = 01b, cumulative values
+		binary.BigEndian.PutUint16(cdb[7:9], uint16(len(buf)))
+		if err := scsiSendCdb(d.fd, cdb[:], sg_dxfer_from_dev, buf); err != nil {
+			return nil, err
+		}
+		if buf[0]&0x3f != page {
+			return nil, fmt.Errorf("invalid LOG SENSE return page: %#02x", buf[0]&0x3f)
+		}
+		full := int(binary.BigEndian.Uint16(buf[2:4])) + 4
+		if full > 0xfffc {
+			full = 0xfffc
+		}
+		if len(buf) >= full {
+			return buf[:full], nil
+		}
+		length = full
+	}
+	return nil, fmt.Errorf("log page %#02x changed size while reading", page)
+}
+
+// parseScsiLogPage splits a LOG SENSE response into parameters
+func parseScsiLogPage(page uint8, buf []byte) (params map[uint16][]byte, err error) {
+	if len(buf) < 4 || buf[0]&0x3f != page {
+		return nil, fmt.Errorf("invalid log page %#02x", page)
+	}
+	end := int(binary.BigEndian.Uint16(buf[2:4])) + 4
+	if end > len(buf) {
+		end = len(buf)
+	}
+	params = make(map[uint16][]byte)
+	for i := 4; i+4 <= end; {
+		code := binary.BigEndian.Uint16(buf[i : i+2])
+		length := int(buf[i+3])
+		if i+4+length > end {
+			return params, fmt.Errorf("truncated parameter %#04x in log page %#02x", code, page)
+		}
+		params[code] = buf[i+4 : i+4+length]
+		i += 4 + length
+	}
+	return
+}
+
+func beUint(b []byte) (v uint64) {
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return
+}
+
+// parameter codes shared by the write, read and verify error counter pages
+var scsi_error_counter_kinds = map[uint16]string{
+	0x0000: "corrected_without_delay",
+	0x0001: "corrected_with_delay",
+	0x0002: "rereads_rewrites",
+	0x0003: "corrected_total",
+	0x0004: "correction_algorithm_invocations",
+	// 0x0005 is total bytes processed, which is not an error
+	0x0006: "uncorrected_total",
+}
+
+// parseScsiErrorCounters decodes error counter log pages (0x02, 0x03, 0x05) into
+// counters keyed by kind. Vendor specific parameters are ignored.
+func parseScsiErrorCounters(params map[uint16][]byte) (out map[string]uint64) {
+	out = make(map[string]uint64)
+	for code, kind := range scsi_error_counter_kinds {
+		if p, ok := params[code]; ok {
+			out[kind] = beUint(p)
+		}
+	}
+	return
+}
+
+// parseScsiTemperature decodes the temperature page (0x0d), a value of 0xff means
+// the temperature is not available and is reported as -1
+func parseScsiTemperature(params map[uint16][]byte) (current, reference int) {
+	current, reference = -1, -1
+	if p, ok := params[0x0000]; ok && len(p) >= 2 && p[1] != 0xff {
+		current = int(p[1])
+	}
+	if p, ok := params[0x0001]; ok && len(p) >= 2 && p[1] != 0xff {
+		reference = int(p[1])
+	}
+	return
+}
+
+type scsiStartStop struct {
+	ManufactureDate           string
+	SpecifiedStartStopCycles  uint64
+	StartStopCycles           uint64
+	SpecifiedLoadUnloadCycles uint64
+	LoadUnloadCycles          uint64
+}
+
+// parseScsiStartStop decodes the start-stop cycle counter page (0x0e)
+func parseScsiStartStop(params map[uint16][]byte) (s scsiStartStop) {
+	// date of manufacture is ASCII, 4 digits of year followed by 2 digits of week
+	if p, ok := params[0x0001]; ok && len(p) >= 6 {
+		s.ManufactureDate = fmt.Sprintf("week %s of year %s", p[4:6], p[0:4])
+	}
+	counters := map[uint16]*uint64{
+		0x0003: &s.SpecifiedStartStopCycles,
+		0x0004: &s.StartStopCycles,
+		0x0005: &s.SpecifiedLoadUnloadCycles,
+		0x0006: &s.LoadUnloadCycles,
+	}
+	for code, v := range counters {
+		if p, ok := params[code]; ok {
+			*v = beUint(p)
+		}
+	}
+	return
+}
+
+// parseScsiInformationalExceptions decodes the informational exceptions page (0x2f).
+// A non zero ASC means the device predicts a failure, most recent temperature is -1
+// if not available.
+func parseScsiInformationalExceptions(params map[uint16][]byte) (asc, ascq uint8, temperature int, ok bool) {
+	p, ok := params[0x0000]
+	if !ok || len(p) < 2 {
+		return 0, 0, -1, false
+	}
+	temperature = -1
+	if len(p) >= 3 && p[2] != 0xff {
+		temperature = int(p[2])
+	}
+	return p[0], p[1], temperature, true
+}
+
+type scsiSelfTest struct {
+	Code        uint8 // 1/2 background short/extended, 5/6 foreground short/extended
+	Result      uint8 // 0 passed, 0xf in progress, see SPC-4 table for the rest
+	Number      uint8
+	PowerOnHour uint16
+	FailedLBA   uint64 // all ones if there is no failure
+}
+
+// parseScsiSelfTests decodes the self-test results page (0x10), newest first.
+// Empty entries are skipped.
+func parseScsiSelfTests(params map[uint16][]byte) (out []scsiSelfTest) {
+	for code := uint16(0x0001); code <= 0x0014; code++ {
+		p, ok := params[code]
+		if !ok || len(p) < 12 {
+			continue
+		}
+		if slices.IndexFunc(p, func(b byte) bool { return b != 0 }) < 0 {
+			continue
+		}
+		out = append(out, scsiSelfTest{
+			Code:        p[0] >> 5,
+			Result:      p[0] & 0xf,
+			Number:      p[1],
+			PowerOnHour: binary.BigEndian.Uint16(p[2:4]),
+			FailedLBA:   binary.BigEndian.Uint64(p[4:12]),
+		})
+	}
+	return
+}
+
+// parseScsiPercentUsed decodes the solid state media page (0x11)
+func parseScsiPercentUsed(params map[uint16][]byte) (uint8, bool) {
+	p, ok := params[0x0001]
+	if !ok || len(p) < 4 {
+		return 0, false
+	}
+	return p[3], true
+}
+
+type scsiSasPhy struct {
+	Port             uint16
+	Phy              uint8
+	InvalidDword     uint32
+	RunningDisparity uint32
+	LossOfDwordSync  uint32
+	PhyResetProblem  uint32
+}
+
+// parseScsiSasPhys decodes the protocol specific port page (0x18) of SAS devices,
+// every parameter is a port holding a descriptor per phy
+func parseScsiSasPhys(params map[uint16][]byte) (out []scsiSasPhy) {
+	for port, p := range params {
+		// protocol identifier 6 is SAS
+		if len(p) < 4 || p[0]&0x0f != 6 {
+			continue
+		}
+		num := int(p[3])
+		for i, desc := 0, p[4:]; i < num && len(desc) >= 48; i++ {
+			out = append(out, scsiSasPhy{
+				Port:             port,
+				Phy:              desc[1],
+				InvalidDword:     binary.BigEndian.Uint32(desc[32:36]),
+				RunningDisparity: binary.BigEndian.Uint32(desc[36:40]),
+				LossOfDwordSync:  binary.BigEndian.Uint32(desc[40:44]),
+				PhyResetProblem:  binary.BigEndian.Uint32(desc[44:48]),
+			})
+			length := int(desc[3]) + 4
+			if length > len(desc) {
+				break
+			}
+			desc = desc[length:]
+		}
+	}
+	return
+}
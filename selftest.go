@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var (
+	errUnknownTestKind      = errors.New("unknown self-test kind")
+	errSelfTestInProgress   = errors.New("a self-test is in progress")
+	errSelfTestNotSupported = errors.New("self-test is not supported by the device")
+)
+
+// SelfTestHandler starts a self-test on POST ?dev=<name>&type=short|extended
+func SelfTestHandler(c *collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := r.FormValue("dev")
+		dev := c.Dev(name)
+		if dev == nil {
+			http.Error(w, "unknown dev "+name, http.StatusNotFound)
+			return
+		}
+		tester, ok := dev.(SelfTester)
+		if !ok {
+			http.Error(w, "self-test is not supported on "+name, http.StatusNotImplemented)
+			return
+		}
+		kind := r.FormValue("type")
+		err := tester.StartSelfTest(kind)
+		switch {
+		case errors.Is(err, errUnknownTestKind):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, errSelfTestInProgress):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, errSelfTestNotSupported):
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+		case err != nil:
+			slog.Error("failed to start self-test", "dev", name, "type", kind, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			slog.Info("started self-test", "dev", name, "type", kind)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}
+}
+
+const (
+	// how often the self-test logs are checked for devices due for a test
+	self_test_check_interval = time.Hour
+	// delay between starting scheduled tests on two devices
+	self_test_stagger = time.Minute
+)
+
+// ScheduleSelfTests starts a short test on every device whose last short test is
+// logged more than the short interval of power-on time ago, and likewise an extended
+// test, until ctx is done. The schedule follows the self-test logs, so restarting the
+// exporter does not move it. A zero interval disables that kind, when both are due
+// only the extended test is started.
+func ScheduleSelfTests(ctx context.Context, c *collector, short, extended time.Duration) {
+	if short <= 0 && extended <= 0 {
+		return
+	}
+	ticker := time.NewTicker(self_test_check_interval)
+	defer ticker.Stop()
+	for {
+		c.startDueSelfTests(ctx, short, extended)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dueSelfTest returns the kind of test due on tester, empty if none is
+func dueSelfTest(tester SelfTester, short, extended time.Duration) (string, error) {
+	intervals := []struct {
+		kind     string
+		interval time.Duration
+	}{
+		{"extended", extended},
+		{"short", short},
+	}
+	for _, i := range intervals {
+		if i.interval <= 0 {
+			continue
+		}
+		hours, ok, err := tester.SelfTestAge(i.kind)
+		if err != nil {
+			return "", err
+		}
+		if !ok || float64(hours) >= i.interval.Hours() {
+			return i.kind, nil
+		}
+	}
+	return "", nil
+}
+
+// startDueSelfTests starts the due tests one device after another, so that devices
+// sharing a controller or power supply do not all start at once
+func (c *collector) startDueSelfTests(ctx context.Context, short, extended time.Duration) {
+	started := false
+	for _, dev := range c.devs {
+		tester, ok := dev.(SelfTester)
+		if !ok {
+			continue
+		}
+		kind, err := dueSelfTest(tester, short, extended)
+		if err != nil {
+			slog.Debug("failed to read self-test log", "dev", dev.Name(), "err", err)
+			continue
+		}
+		if len(kind) == 0 {
+			continue
+		}
+		if started {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(self_test_stagger):
+			}
+		}
+		err = tester.StartSelfTest(kind)
+		switch {
+		case errors.Is(err, errSelfTestInProgress):
+			slog.Debug("scheduled self-test is waiting for the test in progress", "dev", dev.Name(), "type", kind)
+		case err != nil:
+			slog.Warn("failed to start scheduled self-test", "dev", dev.Name(), "type", kind, "err", err)
+		default:
+			started = true
+			slog.Info("started scheduled self-test", "dev", dev.Name(), "type", kind)
+		}
+	}
+}
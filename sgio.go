@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// SG_IO helpers for the commands smart.go does not implement (LOG SENSE,
+// READ CAPACITY(16), ATA logs...)
+
+const (
+	sg_io              = 0x2285
+	sg_info_ok_mask    = 0x1
+	sg_info_ok         = 0x0
+	sg_default_timeout = 20000
+
+	sg_dxfer_none     = -1
+	sg_dxfer_to_dev   = -2
+	sg_dxfer_from_dev = -3
+)
+
+// SCSI ioctl v3 header, see include/scsi/sg.h
+type sgIoHdr struct {
+	interfaceId    int32
+	dxferDirection int32
+	cmdLen         uint8
+	mxSbLen        uint8
+	iovecCount     uint16
+	dxferLen       uint32
+	dxferp         uintptr
+	cmdp           uintptr
+	sbp            uintptr
+	timeout        uint32
+	flags          uint32
+	packId         int32
+	usrPtr         uintptr
+	status         uint8
+	maskedStatus   uint8
+	msgStatus      uint8
+	sbLenWr        uint8
+	hostStatus     uint16
+	driverStatus   uint16
+	resid          int32
+	duration       uint32
+	info           uint32
+}
+
+type sgioError struct {
+	status uint8
+	host   uint16
+	driver uint16
+	sense  []byte
+}
+
+func (e *sgioError) Error() string {
+	return fmt.Sprintf("SCSI status: %#02x, host status: %#02x, driver status: %#02x", e.status, e.host, e.driver)
+}
+
+func openRaw(path string) (int, error) {
+	return unix.Open(path, unix.O_RDWR, 0o600)
+}
+
+func ioctl(fd int, cmd uintptr, ptr unsafe.Pointer) error {
+	_, err := ioctlRet(fd, cmd, ptr)
+	return err
+}
+
+// ioctlRet is ioctl for requests that return a value on success
+func ioctlRet(fd int, cmd uintptr, ptr unsafe.Pointer) (uintptr, error) {
+	r1, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), cmd, uintptr(ptr))
+	if errno != 0 {
+		return r1, errno
+	}
+	return r1, nil
+}
+
+// scsiSendCdb sends cdb to fd, moving buf in direction dir. On CHECK CONDITION the
+// returned *sgioError carries the sense data.
+func scsiSendCdb(fd int, cdb []byte, dir int32, buf []byte) error {
+	sense := make([]byte, 32)
+	hdr := sgIoHdr{
+		interfaceId:    'S',
+		dxferDirection: dir,
+		timeout:        sg_default_timeout,
+		cmdLen:         uint8(len(cdb)),
+		mxSbLen:        uint8(len(sense)),
+		dxferLen:       uint32(len(buf)),
+		cmdp:           uintptr(unsafe.Pointer(&cdb[0])),
+		sbp:            uintptr(unsafe.Pointer(&sense[0])),
+	}
+	if len(buf) != 0 {
+		hdr.dxferp = uintptr(unsafe.Pointer(&buf[0]))
+	}
+	if err := ioctl(fd, sg_io, unsafe.Pointer(&hdr)); err != nil {
+		return err
+	}
+	if hdr.info&sg_info_ok_mask != sg_info_ok {
+		return &sgioError{hdr.status, hdr.hostStatus, hdr.driverStatus, sense[:hdr.sbLenWr]}
+	}
+	return nil
+}
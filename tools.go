@@ -7,6 +7,7 @@ import (
 	"math/big"
 	"os"
 	"os/signal"
+	"path/filepath"
 
 	"github.com/anatol/smart.go"
 )
@@ -17,6 +18,10 @@ func Uint128toFloat64(in smart.Uint128) float64 {
 	return float64(in.Val[0]) + max_uint64*float64(in.Val[1])
 }
 
+func KelvinToCelsius(k uint16) float64 {
+	return float64(k) - 273
+}
+
 func bigFromInt128(int128 smart.Uint128) *big.Int {
 	b := make([]byte, 16)
 	binary.BigEndian.PutUint64(b[:8], int128.Val[1])
@@ -40,3 +45,11 @@ func SignalsCallback(cb func(), once bool, sigs ...os.Signal) {
 		}
 	}()
 }
+
+// devPath turns a name from /sys/block into its device node, absolute paths are kept as is
+func devPath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return "/dev/" + name
+}